@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// RuleFile holds custom rules, passed with -rules
+type RuleFile struct {
+	Rules []CustomRule `json:"rules"`
+}
+
+// CustomRule is a CEL expression over a component, as written in
+// components.yaml, and its pods. The component violates the rule when
+// the expression is true.
+type CustomRule struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Severity    Severity `json:"severity"`
+	Remediation string   `json:"remediation"`
+	Expression  string   `json:"expression"`
+	// Evidence is an optional CEL expression returning a string or a list
+	// of strings, the expression itself is used otherwise
+	Evidence string `json:"evidence,omitempty"`
+}
+
+// celRule evaluates a CustomRule
+type celRule struct {
+	ruleInfo
+	expression cel.Program
+	evidence   cel.Program
+	source     string
+}
+
+func loadRuleFiles(files []string) ([]Rule, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("component", cel.DynType),
+		cel.Variable("pods", cel.ListType(cel.DynType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []Rule{}
+	ids := []string{}
+	for _, r := range builtinRules {
+		ids = append(ids, r.ID())
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var ruleFile RuleFile
+		if err := k8syaml.UnmarshalStrict(data, &ruleFile); err != nil {
+			return nil, fmt.Errorf("unable to decode %s: %w", file, err)
+		}
+		for i, r := range ruleFile.Rules {
+			if r.ID == "" || r.Expression == "" {
+				return nil, fmt.Errorf("%s: rule %d needs an id and an expression", file, i)
+			}
+			if slices.Contains(ids, r.ID) {
+				return nil, fmt.Errorf("%s: duplicate rule id %s", file, r.ID)
+			}
+			if !slices.Contains(severityOrder, r.Severity) {
+				return nil, fmt.Errorf("%s: rule %s: severity must be one of %v", file, r.ID, severityOrder)
+			}
+			rule, err := newCELRule(env, r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %s: %w", file, r.ID, err)
+			}
+			ids = append(ids, r.ID)
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func newCELRule(env *cel.Env, r CustomRule) (celRule, error) {
+	rule := celRule{
+		ruleInfo: ruleInfo{
+			id:          r.ID,
+			title:       r.Title,
+			severity:    r.Severity,
+			remediation: r.Remediation,
+		},
+		source: r.Expression,
+	}
+
+	ast, issues := env.Compile(r.Expression)
+	if issues.Err() != nil {
+		return rule, issues.Err()
+	}
+	if !ast.OutputType().IsAssignableType(cel.BoolType) {
+		return rule, fmt.Errorf("expression must return a bool, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return rule, err
+	}
+	rule.expression = program
+
+	if r.Evidence != "" {
+		ast, issues := env.Compile(r.Evidence)
+		if issues.Err() != nil {
+			return rule, fmt.Errorf("evidence: %w", issues.Err())
+		}
+		if rule.evidence, err = env.Program(ast); err != nil {
+			return rule, fmt.Errorf("evidence: %w", err)
+		}
+	}
+	return rule, nil
+}
+
+func (r celRule) Evaluate(c Component) (Finding, bool) {
+	activation, err := celActivation(c)
+	if err != nil {
+		log.Warnf("Unable to evaluate rule %s on %s: %s", r.id, c.Key(), err)
+		return Finding{}, false
+	}
+	return r.evaluate(c, activation)
+}
+
+// evaluate runs the rule with the activation of the component, which
+// evaluateRules builds once for all the CEL rules
+func (r celRule) evaluate(c Component, activation map[string]interface{}) (Finding, bool) {
+	out, _, err := r.expression.Eval(activation)
+	if err != nil {
+		log.Warnf("Unable to evaluate rule %s on %s: %s", r.id, c.Key(), err)
+		return Finding{}, false
+	}
+	if out.Type() != types.BoolType {
+		log.Warnf("Rule %s returned %s instead of a bool on %s", r.id, out.Type().TypeName(), c.Key())
+		return Finding{}, false
+	}
+	if out != types.True {
+		return Finding{}, false
+	}
+
+	evidence := []string{r.source}
+	if r.evidence != nil {
+		out, _, err := r.evidence.Eval(activation)
+		if err != nil {
+			log.Warnf("Unable to evaluate the evidence of rule %s on %s: %s", r.id, c.Key(), err)
+		} else {
+			evidence = celStrings(out)
+		}
+	}
+
+	pods := []string{}
+	for _, p := range c.Pods {
+		pods = append(pods, p.Namespace+"/"+p.Name)
+	}
+	return r.finding(c, evidence, pods), true
+}
+
+// celActivation exposes the component with the keys of components.yaml, and
+// its pods as returned by the API server
+func celActivation(c Component) (map[string]interface{}, error) {
+	var component map[string]interface{}
+	// yaml.v2 honours the yaml tags of Component, JSON gives string keys
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = k8syaml.YAMLToJSON(data); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &component); err != nil {
+		return nil, err
+	}
+
+	var pods []interface{}
+	if data, err = json.Marshal(c.Pods); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pods); err != nil {
+		return nil, err
+	}
+	if pods == nil {
+		pods = []interface{}{}
+	}
+
+	return map[string]interface{}{
+		"component": component,
+		"pods":      pods,
+	}, nil
+}
+
+// celStrings converts the result of an evidence expression
+func celStrings(v ref.Val) []string {
+	if s, ok := v.Value().(string); ok {
+		return []string{s}
+	}
+	if list, ok := v.(traits.Lister); ok {
+		strings := []string{}
+		for it := list.Iterator(); it.HasNext() == types.True; {
+			strings = append(strings, fmt.Sprint(it.Next().Value()))
+		}
+		return strings
+	}
+	return []string{fmt.Sprint(v.Value())}
+}
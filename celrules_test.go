@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/sfowl/pod-checker/pkg/sachecker"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadRuleFiles(t *testing.T) {
+	rule := func(id, severity, expression string) string {
+		return "- id: " + id + "\n  severity: " + severity + "\n  expression: '" + expression + "'\n"
+	}
+	for _, tc := range []struct {
+		name  string
+		rules string
+		// err is part of the expected error, rules are expected to load
+		// when it's empty
+		err string
+	}{
+		{name: "valid", rules: rule("a", "low", "true") + rule("b", "critical", "component.hostNetwork")},
+		{name: "duplicate id", rules: rule("a", "low", "true") + rule("a", "low", "false"), err: "duplicate rule id a"},
+		{name: "builtin id", rules: rule("scc-more-restrictive-available", "low", "true"), err: "duplicate rule id"},
+		{name: "missing id", rules: rule("", "low", "true"), err: "needs an id and an expression"},
+		{name: "missing expression", rules: rule("a", "low", ""), err: "needs an id and an expression"},
+		{name: "bad severity", rules: rule("a", "urgent", "true"), err: "severity must be one of"},
+		{name: "not a bool", rules: rule("a", "low", `"yes"`), err: "must return a bool"},
+		{name: "invalid expression", rules: rule("a", "low", "component.("), err: "rule a"},
+		{name: "unknown variable", rules: rule("a", "low", "service.public"), err: "undeclared reference"},
+		{name: "unknown field", rules: rule("a", "low", "true") + "  enabled: true\n", err: "unable to decode"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(file, []byte("rules:\n"+tc.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			rules, err := loadRuleFiles([]string{file})
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case tc.err == "" && len(rules) != strings.Count(tc.rules, "- id:"):
+				t.Fatalf("expected all the rules, got %d", len(rules))
+			case tc.err != "" && err == nil:
+				t.Fatalf("expected an error containing %q", tc.err)
+			case tc.err != "" && !strings.Contains(err.Error(), tc.err):
+				t.Fatalf("expected an error containing %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestExampleRules(t *testing.T) {
+	rules, err := loadRuleFiles([]string{"example/input/rules.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := func(name string, images ...string) corev1.Pod {
+		p := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"}}
+		for i, image := range images {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: fmt.Sprintf("c%d", i), Image: image})
+		}
+		return p
+	}
+	components := map[string]Component{
+		"web": {
+			Name:        "web",
+			Namespace:   "shop",
+			Group:       "shop",
+			DeployedAs:  "Deployment",
+			RunLevel:    "1",
+			HostNetwork: true,
+			Pods:        []corev1.Pod{pod("web-1", "nginx:latest", "registry.example.com/proxy@sha256:abc")},
+			RBAC: &sachecker.Permissions{
+				SecretsAccess: true,
+				Rules: []sachecker.PermissionRule{
+					{Verbs: []string{"get"}, Resources: []string{"secrets"}, Role: "Role/reader", Binding: "RoleBinding/shop/web"},
+					{Verbs: []string{"get"}, Resources: []string{"configmaps"}, Role: "Role/config", Binding: "RoleBinding/shop/config"},
+					{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}, Role: "ClusterRole/health", Binding: "ClusterRoleBinding/health"},
+				},
+			},
+		},
+		"db": {
+			Name:       "db",
+			Namespace:  "shop",
+			Group:      "shop",
+			DeployedAs: "StatefulSet",
+			RunLevel:   "0",
+			Pods:       []corev1.Pod{pod("db-0", "postgres:15")},
+		},
+	}
+
+	findings := evaluateRules(components, rules)
+
+	expected := map[string][]string{
+		"host-network-outside-run-level-0": {"hostNetwork in namespace shop of run-level '1'"},
+		"rbac-secrets-access":              {"Role/reader bound by RoleBinding/shop/web"},
+		"latest-image-tag":                 {"c0 runs nginx:latest"},
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("expected %d findings, got %+v", len(expected), findings)
+	}
+	for _, f := range findings {
+		if f.Component != components["web"].Key() {
+			t.Errorf("unexpected finding %s on %s", f.ID, f.Component)
+		}
+		if !slices.Equal(f.Evidence, expected[f.ID]) {
+			t.Errorf("%s: expected evidence %q, got %q", f.ID, expected[f.ID], f.Evidence)
+		}
+		if !slices.Equal(f.Pods, []string{"shop/web-1"}) {
+			t.Errorf("%s: unexpected pods %v", f.ID, f.Pods)
+		}
+	}
+}
+
+func TestCELStrings(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		value    interface{}
+		expected []string
+	}{
+		{name: "string", value: "runs as root", expected: []string{"runs as root"}},
+		{name: "list", value: []string{"a", "b"}, expected: []string{"a", "b"}},
+		{name: "empty list", value: []string{}, expected: []string{}},
+		{name: "mixed list", value: []interface{}{"a", 1}, expected: []string{"a", "1"}},
+		{name: "int", value: 3, expected: []string{"3"}},
+		{name: "bool", value: true, expected: []string{"true"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := celStrings(types.DefaultTypeAdapter.NativeToValue(tc.value)); !slices.Equal(got, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CollectionStatus records whether a resource type could be gathered, so
+// reports built from partial data say what is missing.
+type CollectionStatus struct {
+	Resource  string              `yaml:"resource"`
+	Collected bool                `yaml:"collected"`
+	Count     int                 `yaml:"count"`
+	Reason    metav1.StatusReason `yaml:"reason,omitempty"`
+	Error     string              `yaml:"error,omitempty"`
+}
+
+// CollectionError is returned when a resource type can't be gathered
+type CollectionError struct {
+	Resource string
+	Reason   metav1.StatusReason
+	Err      error
+}
+
+func newCollectionError(resource string, err error) *CollectionError {
+	reason := apierrors.ReasonForError(err)
+	if reason == metav1.StatusReasonUnknown && apierrors.IsNotFound(err) {
+		reason = metav1.StatusReasonNotFound
+	}
+	return &CollectionError{
+		Resource: resource,
+		Reason:   reason,
+		Err:      err,
+	}
+}
+
+func (e *CollectionError) Error() string {
+	if e.Reason != metav1.StatusReasonUnknown {
+		return fmt.Sprintf("unable to collect %s (%s): %s", e.Resource, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("unable to collect %s: %s", e.Resource, e.Err)
+}
+
+func (e *CollectionError) Unwrap() error {
+	return e.Err
+}
+
+// collected records a successfully gathered resource type
+func (d *ClusterData) collected(resource string, count int) {
+	d.Status = append(d.Status, CollectionStatus{
+		Resource:  resource,
+		Collected: true,
+		Count:     count,
+	})
+}
+
+// isCollected tells if a resource type was gathered
+func (d *ClusterData) isCollected(resource string) bool {
+	for _, s := range d.Status {
+		if s.Resource == resource {
+			return s.Collected
+		}
+	}
+	return false
+}
+
+// missing records a resource type that could not be gathered. Analysis
+// continues without it.
+func (d *ClusterData) missing(err *CollectionError) {
+	log.Warnf("Continuing without %s: %s", err.Resource, err)
+	d.Status = append(d.Status, CollectionStatus{
+		Resource: err.Resource,
+		Reason:   err.Reason,
+		Error:    err.Err.Error(),
+	})
+}
@@ -6,31 +6,57 @@ import (
 	"strings"
 
 	routev1 "github.com/openshift/api/route/v1"
+	"github.com/sfowl/pod-checker/pkg/sachecker"
 	corev1 "k8s.io/api/core/v1"
 )
 
 type Component struct {
-	Name                string
-	Namespace           string
-	Group               string
-	DeployedAs          string                   `yaml:"deployedAs"`
-	RunsOn              string                   `yaml:"runsOn"`
-	IsOperator          bool                     `yaml:"IsOperator"`
-	SecurityContext     ComponentSecurityContext `yaml:"securityContext"`
-	SCC                 string
-	RunLevel            string           `yaml:"runLevel"`
-	HostIPC             bool             `yaml:"hostIPC"`
-	HostNetwork         bool             `yaml:"hostNetwork"`
-	HostPID             bool             `yaml:"hostPID"`
-	PriorityClass       string           `yaml:"priorityClass"`
-	InboundTraffic      bool             `yaml:"inboundTraffic"`
-	ExternallyExposed   bool             `yaml:"externallyExposed"`
-	IncomingConnections []string         `yaml:"incomingConnections"`
-	OutgoingConnections []string         `yaml:"outgoingConnections"`
-	HostMounts          []string         `yaml:"hostMounts"`
-	Pods                []corev1.Pod     `yaml:"-"`
-	Services            []corev1.Service `yaml:"-"`
-	Routes              []routev1.Route  `yaml:"-"`
+	Name            string
+	Namespace       string
+	Group           string
+	DeployedAs      string                   `yaml:"deployedAs"`
+	RunsOn          string                   `yaml:"runsOn"`
+	IsOperator      bool                     `yaml:"IsOperator"`
+	SecurityContext ComponentSecurityContext `yaml:"securityContext"`
+	SCC             string
+	// SCCDetails are the privileges of the SCC and the SCCs the service
+	// account may use, nil when SCCs couldn't be collected
+	SCCDetails       *ComponentSCC `yaml:"sccDetails,omitempty"`
+	PodSecurityLevel string        `yaml:"podSecurityLevel,omitempty"`
+	// PodSecurity is the Pod Security Standards level the pods satisfy
+	PodSecurity       *PodSecurity `yaml:"podSecurity,omitempty"`
+	RunLevel          string       `yaml:"runLevel"`
+	HostIPC           bool         `yaml:"hostIPC"`
+	HostNetwork       bool         `yaml:"hostNetwork"`
+	HostPID           bool         `yaml:"hostPID"`
+	PriorityClass     string       `yaml:"priorityClass"`
+	InboundTraffic    bool         `yaml:"inboundTraffic"`
+	ExternallyExposed bool         `yaml:"externallyExposed"`
+	// ExposedBy lists how the component is reachable from outside the
+	// cluster: Route, Ingress, HTTPRoute, NodePort, LoadBalancer,
+	// ExternalIP or HostPort
+	ExposedBy           []string     `yaml:"exposedBy,omitempty"`
+	IncomingConnections []Connection `yaml:"incomingConnections"`
+	OutgoingConnections []Connection `yaml:"outgoingConnections"`
+	HostMounts          []string     `yaml:"hostMounts"`
+	// NetworkPolicy is nil when network policies couldn't be collected
+	NetworkPolicy *ComponentNetworkPolicy `yaml:"networkPolicy,omitempty"`
+	// ServiceDetails and RouteDetails summarize Services and Routes, and
+	// the Ingresses and HTTPRoutes of non OpenShift clusters
+	ServiceDetails []ServiceInfo `yaml:"services,omitempty"`
+	RouteDetails   []RouteInfo   `yaml:"routes,omitempty"`
+	// InsecureExposures are the routes serving the component over plain HTTP
+	InsecureExposures []string `yaml:"insecureExposures,omitempty"`
+	// RBAC is the effective permissions of the pods' service account, nil
+	// when RBAC couldn't be collected
+	RBAC *sachecker.Permissions `yaml:"rbac,omitempty"`
+	// ServiceAccountTokens is how the service account token is mounted
+	ServiceAccountTokens *sachecker.Tokens `yaml:"serviceAccountTokens,omitempty"`
+	// Addresses of external components, as seen in flows
+	Addresses []string         `yaml:"addresses,omitempty"`
+	Pods      []corev1.Pod     `yaml:"-"`
+	Services  []corev1.Service `yaml:"-"`
+	Routes    []routev1.Route  `yaml:"-"`
 }
 
 func (c Component) Key() string {
@@ -54,6 +80,9 @@ func (c Component) Values() []string {
 		c.RunsOn,
 		strconv.FormatBool(c.IsOperator),
 		c.SCC,
+		c.SCCDetails.String(),
+		c.PodSecurityLevel,
+		c.PodSecurity.String(),
 		c.RunLevel,
 		strconv.FormatBool(c.HostIPC),
 		strconv.FormatBool(c.HostNetwork),
@@ -70,8 +99,15 @@ func (c Component) Values() []string {
 		c.PriorityClass,
 		strconv.FormatBool(c.InboundTraffic),
 		strconv.FormatBool(c.ExternallyExposed),
-		strings.Join(c.IncomingConnections, ","),
-		strings.Join(c.OutgoingConnections, ","),
+		strings.Join(c.ExposedBy, ","),
+		c.NetworkPolicy.Isolation(),
+		strings.Join(c.NetworkPolicy.policies(), ","),
+		strings.Join(connectionKeys(c.IncomingConnections), ","),
+		strings.Join(connectionKeys(c.OutgoingConnections), ","),
+		strings.Join(serviceInfoStrings(c.ServiceDetails), ";"),
+		strings.Join(routeInfoStrings(c.RouteDetails), ";"),
+		strings.Join(c.RBAC.Flags(), ","),
+		c.ServiceAccountTokens.String(),
 		strings.Join(c.HostMounts, ","),
 	}
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// ephemeralPortStart is the first port of the Linux ephemeral range, the
+// client side of most connections
+const ephemeralPortStart = 32768
+
+// guessReplyFlows enables the port heuristic of isReplyFlow for flows whose
+// exporter doesn't tell replies apart, set with -guess-replies
+var guessReplyFlows bool
+
+// protocolNames are the L4 protocols of flows, by IANA protocol number
+var protocolNames = map[string]string{
+	"1":   "ICMP",
+	"6":   "TCP",
+	"17":  "UDP",
+	"58":  "ICMPv6",
+	"132": "SCTP",
+}
+
+// Connection is the traffic observed between a component and a peer,
+// aggregated over all the flows between them
+type Connection struct {
+	Component string `yaml:"component"`
+	// Ports the peer was reached on, as protocol/port, e.g. TCP/6443
+	Ports     []string  `yaml:"ports,omitempty"`
+	Bytes     uint64    `yaml:"bytes"`
+	Packets   uint64    `yaml:"packets"`
+	FirstSeen time.Time `yaml:"firstSeen,omitempty"`
+	LastSeen  time.Time `yaml:"lastSeen,omitempty"`
+}
+
+// addFlow aggregates a flow into the connection
+func (c *Connection) addFlow(f FlowData) {
+	if port := flowPort(f); port != "" && !slices.Contains(c.Ports, port) {
+		c.Ports = append(c.Ports, port)
+		slices.Sort(c.Ports)
+	}
+	// the same traffic seen on another interface
+	if f.Duplicate != "true" {
+		c.Bytes += parseFlowCounter(f.Bytes)
+		c.Packets += parseFlowCounter(f.Packets)
+	}
+
+	start, end := flowTimes(f)
+	if !start.IsZero() && (c.FirstSeen.IsZero() || start.Before(c.FirstSeen)) {
+		c.FirstSeen = start
+	}
+	if end.After(c.LastSeen) {
+		c.LastSeen = end
+	}
+}
+
+// Rate is the volume of the connection per hour, empty when the flows don't
+// span enough time to tell
+func (c Connection) Rate() string {
+	span := c.LastSeen.Sub(c.FirstSeen)
+	if c.FirstSeen.IsZero() || span < time.Minute {
+		return ""
+	}
+	return fmt.Sprintf("%s/hour", formatBytes(float64(c.Bytes)/span.Hours()))
+}
+
+// Summary describes the connection in a few words, e.g. "TCP/6443, 40.0 MB/hour"
+func (c Connection) Summary() string {
+	summary := strings.Join(c.Ports, ", ")
+	if rate := c.Rate(); rate != "" {
+		if summary != "" {
+			summary += ", "
+		}
+		summary += rate
+	}
+	return summary
+}
+
+// addConnection aggregates a flow into the connection to peer, adding the
+// connection if it's the first flow seen
+func addConnection(connections []Connection, peer string, f FlowData) []Connection {
+	i := slices.IndexFunc(connections, func(c Connection) bool { return c.Component == peer })
+	if i < 0 {
+		connections = append(connections, Connection{Component: peer})
+		i = len(connections) - 1
+	}
+	connections[i].addFlow(f)
+	return connections
+}
+
+func connectionKeys(connections []Connection) []string {
+	keys := make([]string, 0, len(connections))
+	for _, c := range connections {
+		keys = append(keys, c.Component)
+	}
+	return keys
+}
+
+// isReplyFlow tells if a flow goes from the server side back to the client,
+// as reported by the exporter. When the exporter doesn't say and
+// guessReplyFlows is set, a flow from a well known port to an ephemeral one
+// is a reply.
+func isReplyFlow(f FlowData) bool {
+	if f.Reply != "" {
+		return f.Reply == "true"
+	}
+	if !guessReplyFlows {
+		return false
+	}
+	srcPort, err := strconv.Atoi(f.SrcPort)
+	if err != nil || srcPort == 0 {
+		return false
+	}
+	dstPort, err := strconv.Atoi(f.DstPort)
+	if err != nil {
+		return false
+	}
+	return srcPort < ephemeralPortStart && dstPort >= ephemeralPortStart
+}
+
+// reverseFlow swaps the source and destination of a flow
+func reverseFlow(f FlowData) FlowData {
+	f.SrcK8S_Namespace, f.DstK8S_Namespace = f.DstK8S_Namespace, f.SrcK8S_Namespace
+	f.SrcK8S_Name, f.DstK8S_Name = f.DstK8S_Name, f.SrcK8S_Name
+	f.SrcK8S_Type, f.DstK8S_Type = f.DstK8S_Type, f.SrcK8S_Type
+	f.SrcK8S_OwnerType, f.DstK8S_OwnerType = f.DstK8S_OwnerType, f.SrcK8S_OwnerType
+	f.SrcK8S_OwnerName, f.DstK8S_OwnerName = f.DstK8S_OwnerName, f.SrcK8S_OwnerName
+	f.SrcAddr, f.DstAddr = f.DstAddr, f.SrcAddr
+	f.SrcPort, f.DstPort = f.DstPort, f.SrcPort
+	f.SrcMac, f.DstMac = f.DstMac, f.SrcMac
+	return f
+}
+
+// flowPort is the protocol/port a flow is sent to, or just the protocol for
+// protocols without ports
+func flowPort(f FlowData) string {
+	protocol, ok := protocolNames[f.Proto]
+	if !ok {
+		if f.Proto == "" {
+			return ""
+		}
+		protocol = "proto-" + f.Proto
+	}
+	if f.DstPort == "" || f.DstPort == "0" {
+		return protocol
+	}
+	return protocol + "/" + f.DstPort
+}
+
+// parseFlowCounter reads a bytes or packets count, written as an integer or
+// (by some exporters) a float
+func parseFlowCounter(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && f > 0 {
+		return uint64(f)
+	}
+	return 0
+}
+
+// flowTimes are the start and end of a flow. Flows without TimeFlowStartMs
+// and TimeFlowEndMs fall back to TimeReceived, in seconds.
+func flowTimes(f FlowData) (time.Time, time.Time) {
+	start := parseFlowTime(f.TimeFlowStartMs, time.Millisecond)
+	end := parseFlowTime(f.TimeFlowEndMs, time.Millisecond)
+	if start.IsZero() && end.IsZero() {
+		received := parseFlowTime(f.TimeReceived, time.Second)
+		return received, received
+	}
+	if start.IsZero() {
+		start = end
+	}
+	if end.IsZero() {
+		end = start
+	}
+	return start, end
+}
+
+// parseFlowTime reads an epoch timestamp in the given unit. CSV exports
+// write these in exponent notation, e.g. 1.681781299958e+12.
+func parseFlowTime(s string, unit time.Duration) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return time.Time{}
+	}
+	// flow timestamps are at most millisecond precise
+	return time.UnixMilli(int64(math.Round(v * float64(unit/time.Millisecond)))).UTC()
+}
+
+func formatBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for b >= 1000 && i < len(units)-1 {
+		b /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
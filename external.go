@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"sigs.k8s.io/yaml"
+)
+
+// externalGroup holds the synthetic components of flow endpoints outside the
+// cluster's workloads: nodes and external addresses
+const externalGroup = "external"
+
+const (
+	deployedAsNode     = "Node"
+	deployedAsExternal = "External"
+)
+
+const reverseDNSTimeout = 2 * time.Second
+
+// ExternalNetwork names the addresses of a set of CIDRs, e.g. a cloud API or
+// a corporate network. Read from the file passed with -external-cidrs.
+type ExternalNetwork struct {
+	Name  string   `json:"name"`
+	CIDRs []string `json:"cidrs"`
+}
+
+type externalPrefix struct {
+	name   string
+	prefix netip.Prefix
+}
+
+// externalEndpoints names the flow endpoints that are not workloads
+type externalEndpoints struct {
+	// prefixes, most specific first
+	prefixes []externalPrefix
+	// resolve names the remaining addresses with reverse DNS
+	resolve bool
+	names   map[string]string
+}
+
+func loadExternalNetworks(file string) ([]ExternalNetwork, error) {
+	var networks []ExternalNetwork
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, &networks); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", file, err)
+	}
+	return networks, nil
+}
+
+func newExternalEndpoints(networks []ExternalNetwork, resolve bool) (*externalEndpoints, error) {
+	e := &externalEndpoints{
+		resolve: resolve,
+		names:   make(map[string]string),
+	}
+	for i, n := range networks {
+		if n.Name == "" {
+			return nil, fmt.Errorf("network %d has no name", i)
+		}
+		for _, cidr := range n.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("network %s: %w", n.Name, err)
+			}
+			e.prefixes = append(e.prefixes, externalPrefix{name: n.Name, prefix: prefix.Masked()})
+		}
+	}
+	sort.SliceStable(e.prefixes, func(i, j int) bool {
+		return e.prefixes[i].prefix.Bits() > e.prefixes[j].prefix.Bits()
+	})
+	return e, nil
+}
+
+// name of an external address: its network, its reverse DNS name, or the
+// address itself
+func (e *externalEndpoints) name(address string) string {
+	if name, ok := e.names[address]; ok {
+		return name
+	}
+
+	name := address
+	if addr, err := netip.ParseAddr(address); err == nil {
+		addr = addr.Unmap()
+		if i := slices.IndexFunc(e.prefixes, func(p externalPrefix) bool { return p.prefix.Contains(addr) }); i >= 0 {
+			name = e.prefixes[i].name
+		} else if e.resolve {
+			name = reverseDNS(address)
+		}
+	}
+
+	e.names[address] = name
+	return name
+}
+
+func reverseDNS(address string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, address)
+	if err != nil || len(names) == 0 {
+		log.Debugf("No reverse DNS name for %s: %v", address, err)
+		return address
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// component returns the synthetic component of a flow endpoint, when it's a
+// node or an address without a workload
+func (e *externalEndpoints) component(kind, ownerType, ownerName, name, hostName, address string) (Component, bool) {
+	if kind == "Node" || ownerType == "Node" {
+		nodeName := ownerName
+		for _, n := range []string{name, hostName, address} {
+			if nodeName == "" {
+				nodeName = n
+			}
+		}
+		if nodeName == "" {
+			return Component{}, false
+		}
+		return Component{
+			Name:       nodeName,
+			Group:      externalGroup,
+			DeployedAs: deployedAsNode,
+			HostMounts: []string{},
+		}, true
+	}
+
+	if ownerName != "" || name != "" || address == "" {
+		// a workload, or a service, that should be known
+		return Component{}, false
+	}
+	return Component{
+		Name:       e.name(address),
+		Group:      externalGroup,
+		DeployedAs: deployedAsExternal,
+		HostMounts: []string{},
+	}, true
+}
+
+// addExternalComponent returns the key of an endpoint's synthetic component,
+// adding it to components if needed
+func addExternalComponent(components map[string]Component, c Component, address string) string {
+	key := c.Key()
+	if existing, ok := components[key]; ok {
+		c = existing
+	}
+	if address != "" && !slices.Contains(c.Addresses, address) {
+		c.Addresses = append(c.Addresses, address)
+		slices.Sort(c.Addresses)
+	}
+	components[key] = c
+	return key
+}
+
+// IsExternal tells if the component is a synthetic node or external endpoint
+func (c Component) IsExternal() bool {
+	return c.Group == externalGroup && (c.DeployedAs == deployedAsNode || c.DeployedAs == deployedAsExternal)
+}
+
+// hasPublicAddress tells if any of the component's addresses is on the
+// internet
+func (c Component) hasPublicAddress() bool {
+	for _, a := range c.Addresses {
+		if addr, err := netip.ParseAddr(a); err == nil && addr.Unmap().IsGlobalUnicast() && !addr.Unmap().IsPrivate() {
+			return true
+		}
+	}
+	return false
+}
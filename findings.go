@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityHigh     Severity = "high"
+	SeverityMedium   Severity = "medium"
+	SeverityLow      Severity = "low"
+)
+
+// severityOrder sorts findings, most severe first
+var severityOrder = []Severity{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow}
+
+// Finding is a rule violated by a component
+type Finding struct {
+	ID          string   `yaml:"id" json:"id"`
+	Title       string   `yaml:"title" json:"title"`
+	Severity    Severity `yaml:"severity" json:"severity"`
+	Component   string   `yaml:"component" json:"component"`
+	Evidence    []string `yaml:"evidence" json:"evidence"`
+	Remediation string   `yaml:"remediation" json:"remediation"`
+	// Pods are the affected pods, namespace/name
+	Pods []string `yaml:"pods,omitempty" json:"pods,omitempty"`
+}
+
+// Rule checks components, returning a finding when one doesn't comply
+type Rule interface {
+	ID() string
+	Evaluate(c Component) (Finding, bool)
+}
+
+// ruleInfo describes the findings of a rule
+type ruleInfo struct {
+	id          string
+	title       string
+	severity    Severity
+	remediation string
+}
+
+func (r ruleInfo) ID() string {
+	return r.id
+}
+
+func (r ruleInfo) finding(c Component, evidence []string, pods []string) Finding {
+	return Finding{
+		ID:          r.id,
+		Title:       r.title,
+		Severity:    r.severity,
+		Component:   c.Key(),
+		Evidence:    evidence,
+		Remediation: r.remediation,
+		Pods:        pods,
+	}
+}
+
+// podRule checks each pod of a component, the finding gathers the evidence
+// of all its pods
+type podRule struct {
+	ruleInfo
+	check func(p corev1.Pod) []string
+}
+
+func (r podRule) Evaluate(c Component) (Finding, bool) {
+	evidence := []string{}
+	pods := []string{}
+	for _, p := range c.Pods {
+		podEvidence := r.check(p)
+		if len(podEvidence) == 0 {
+			continue
+		}
+		evidence = appendUnique(evidence, podEvidence...)
+		pods = append(pods, p.Namespace+"/"+p.Name)
+	}
+	if len(evidence) == 0 {
+		return Finding{}, false
+	}
+	return r.finding(c, evidence, pods), true
+}
+
+// componentRule checks the attributes of a component as a whole
+type componentRule struct {
+	ruleInfo
+	check func(c Component) []string
+}
+
+func (r componentRule) Evaluate(c Component) (Finding, bool) {
+	evidence := r.check(c)
+	if len(evidence) == 0 {
+		return Finding{}, false
+	}
+	pods := []string{}
+	for _, p := range c.Pods {
+		pods = append(pods, p.Namespace+"/"+p.Name)
+	}
+	return r.finding(c, evidence, pods), true
+}
+
+// dangerousCapabilities allow escaping the container or taking over the
+// node, or its network
+var dangerousCapabilities = []corev1.Capability{
+	"ALL",
+	"SYS_ADMIN",
+	"SYS_MODULE",
+	"SYS_PTRACE",
+	"SYS_RAWIO",
+	"SYS_BOOT",
+	"DAC_READ_SEARCH",
+	"BPF",
+	"NET_ADMIN",
+	"NET_RAW",
+}
+
+// sensitiveHostPaths give access to the node's configuration, credentials,
+// or container runtime. Directories include everything below them.
+var sensitiveHostPaths = []string{
+	"/",
+	"/etc",
+	"/root",
+	"/home",
+	"/proc",
+	"/sys",
+	"/dev",
+	"/boot",
+	"/var/lib/kubelet",
+	"/var/lib/etcd",
+	"/var/run/docker.sock",
+	"/var/run/crio",
+	"/run/containerd",
+	"/var/run/containerd",
+	"/run/crio",
+}
+
+var builtinRules = []Rule{
+	podRule{
+		ruleInfo: ruleInfo{
+			id:          "privileged-container",
+			title:       "Privileged container",
+			severity:    SeverityCritical,
+			remediation: "Remove privileged: true, add only the capabilities the container needs",
+		},
+		check: func(p corev1.Pod) []string {
+			evidence := []string{}
+			for _, c := range podContainers(p) {
+				if sc := c.SecurityContext; sc != nil && sc.Privileged != nil && *sc.Privileged {
+					evidence = append(evidence, fmt.Sprintf("container %s is privileged", c.Name))
+				}
+			}
+			return evidence
+		},
+	},
+	podRule{
+		ruleInfo: ruleInfo{
+			id:          "host-namespaces",
+			title:       "Pod shares the node's namespaces",
+			severity:    SeverityHigh,
+			remediation: "Remove hostNetwork, hostPID and hostIPC unless the component manages the node",
+		},
+		check: func(p corev1.Pod) []string {
+			evidence := []string{}
+			if p.Spec.HostNetwork {
+				evidence = append(evidence, "hostNetwork")
+			}
+			if p.Spec.HostPID {
+				evidence = append(evidence, "hostPID")
+			}
+			if p.Spec.HostIPC {
+				evidence = append(evidence, "hostIPC")
+			}
+			return evidence
+		},
+	},
+	podRule{
+		ruleInfo: ruleInfo{
+			id:          "dangerous-capabilities",
+			title:       "Container adds dangerous capabilities",
+			severity:    SeverityHigh,
+			remediation: "Drop ALL capabilities and only add the ones needed, avoid SYS_ADMIN, NET_ADMIN, SYS_PTRACE and the like",
+		},
+		check: func(p corev1.Pod) []string {
+			evidence := []string{}
+			for _, c := range podContainers(p) {
+				if c.SecurityContext == nil || c.SecurityContext.Capabilities == nil {
+					continue
+				}
+				for _, capability := range c.SecurityContext.Capabilities.Add {
+					name := corev1.Capability(strings.TrimPrefix(strings.ToUpper(string(capability)), "CAP_"))
+					if slices.Contains(dangerousCapabilities, name) {
+						evidence = append(evidence, fmt.Sprintf("container %s adds %s", c.Name, name))
+					}
+				}
+			}
+			return evidence
+		},
+	},
+	podRule{
+		ruleInfo: ruleInfo{
+			id:          "writable-root-filesystem",
+			title:       "Container root filesystem is writable",
+			severity:    SeverityLow,
+			remediation: "Set readOnlyRootFilesystem: true, and mount emptyDir volumes where the container writes",
+		},
+		check: func(p corev1.Pod) []string {
+			evidence := []string{}
+			for _, c := range podContainers(p) {
+				if sc := c.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+					evidence = append(evidence, fmt.Sprintf("container %s has a writable root filesystem", c.Name))
+				}
+			}
+			return evidence
+		},
+	},
+	podRule{
+		ruleInfo: ruleInfo{
+			id:          "sensitive-host-path",
+			title:       "Sensitive host path mounted",
+			severity:    SeverityHigh,
+			remediation: "Avoid hostPath volumes, or restrict them to the files needed, read-only",
+		},
+		check: func(p corev1.Pod) []string {
+			evidence := []string{}
+			for _, v := range p.Spec.Volumes {
+				if v.HostPath == nil || !isSensitiveHostPath(v.HostPath.Path) {
+					continue
+				}
+				e := fmt.Sprintf("volume %s mounts %s", v.Name, v.HostPath.Path)
+				if hostPathReadOnly(p, v.Name) {
+					e += " read-only"
+				}
+				evidence = append(evidence, e)
+			}
+			return evidence
+		},
+	},
+	componentRule{
+		ruleInfo: ruleInfo{
+			id:          "external-exposure",
+			title:       "Component reachable from outside the cluster",
+			severity:    SeverityMedium,
+			remediation: "Check the exposure is intended, prefer TLS Routes or Ingresses to node ports, external IPs and host ports",
+		},
+		check: func(c Component) []string {
+			evidence := []string{}
+			for _, e := range c.ExposedBy {
+				evidence = append(evidence, "exposed by "+e)
+			}
+			return evidence
+		},
+	},
+	componentRule{
+		ruleInfo: ruleInfo{
+			id:          "plain-http-exposure",
+			title:       "Component served over plain HTTP outside the cluster",
+			severity:    SeverityHigh,
+			remediation: "Configure TLS on the route, and set insecureEdgeTerminationPolicy to Redirect or None",
+		},
+		check: func(c Component) []string {
+			return c.InsecureExposures
+		},
+	},
+	componentRule{
+		ruleInfo: ruleInfo{
+			id:          "scc-more-restrictive-available",
+			title:       "Pods could run under a more restrictive SCC",
+			severity:    SeverityLow,
+			remediation: "Stop granting the current SCC to the service account, or pin the restrictive one with the openshift.io/required-scc annotation",
+		},
+		check: func(c Component) []string {
+			evidence := []string{}
+			if c.SCCDetails == nil || c.SCCDetails.Current == nil {
+				return evidence
+			}
+			for _, scc := range c.SCCDetails.MoreRestrictive {
+				evidence = append(evidence, fmt.Sprintf("runs under %s, %s would admit the pods", c.SCCDetails.Current.Name, scc))
+			}
+			return evidence
+		},
+	},
+	componentRule{
+		ruleInfo: ruleInfo{
+			id:          "pod-security-namespace-level",
+			title:       "Pods don't satisfy the Pod Security level of their namespace",
+			severity:    SeverityMedium,
+			remediation: "Fix the violations of the namespace's level, or label the namespace with the level the component needs",
+		},
+		check: func(c Component) []string {
+			evidence := []string{}
+			if c.PodSecurity == nil || c.PodSecurity.Namespace == nil {
+				return evidence
+			}
+			levels := map[string]string{
+				podSecurityEnforce: c.PodSecurity.Namespace.Enforce,
+				podSecurityAudit:   c.PodSecurity.Namespace.Audit,
+				podSecurityWarn:    c.PodSecurity.Namespace.Warn,
+			}
+			for _, mode := range c.PodSecurity.NamespaceViolations {
+				evidence = append(evidence, fmt.Sprintf("violates %s level %s, satisfies %s", mode, levels[mode], c.PodSecurity.Level))
+			}
+			return evidence
+		},
+	},
+}
+
+// evaluateRules runs the rules against the components, findings are sorted
+// by severity, then component
+func evaluateRules(components map[string]Component, rules []Rule) []Finding {
+	findings := []Finding{}
+	for _, c := range components {
+		if c.IsExternal() {
+			continue
+		}
+		// the CEL rules share the conversion of the component
+		var activation map[string]interface{}
+		var activationErr error
+		for _, r := range rules {
+			var f Finding
+			var ok bool
+			if cr, isCEL := r.(celRule); isCEL {
+				if activation == nil && activationErr == nil {
+					if activation, activationErr = celActivation(c); activationErr != nil {
+						log.Warnf("Unable to evaluate the custom rules on %s: %s", c.Key(), activationErr)
+					}
+				}
+				if activationErr != nil {
+					continue
+				}
+				f, ok = cr.evaluate(c, activation)
+			} else {
+				f, ok = r.Evaluate(c)
+			}
+			if ok {
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return slices.Index(severityOrder, a.Severity) < slices.Index(severityOrder, b.Severity)
+		}
+		if a.Component != b.Component {
+			return a.Component < b.Component
+		}
+		return a.ID < b.ID
+	})
+	return findings
+}
+
+func writeFindings(findings []Finding, yamlFile string, jsonFile string) error {
+	writeYAML(marshalYAML(findings), yamlFile)
+
+	data, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(jsonFile, data, 0644)
+}
+
+// podContainers are the init and regular containers of a pod
+func podContainers(p corev1.Pod) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(p.Spec.InitContainers)+len(p.Spec.Containers))
+	containers = append(containers, p.Spec.InitContainers...)
+	return append(containers, p.Spec.Containers...)
+}
+
+func isSensitiveHostPath(path string) bool {
+	path = "/" + strings.Trim(path, "/")
+	for _, s := range sensitiveHostPaths {
+		if path == s || (s != "/" && strings.HasPrefix(path, s+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPathReadOnly tells if all the mounts of a volume are read-only
+func hostPathReadOnly(p corev1.Pod, volume string) bool {
+	mounted := false
+	for _, c := range podContainers(p) {
+		for _, m := range c.VolumeMounts {
+			if m.Name != volume {
+				continue
+			}
+			if !m.ReadOnly {
+				return false
+			}
+			mounted = true
+		}
+	}
+	return mounted
+}
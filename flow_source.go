@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const autoFlowFormat = "auto"
+
+// FlowSource reads network flows of one format
+type FlowSource interface {
+	ReadFlows(r io.Reader) ([]FlowData, error)
+}
+
+// FlowSourceFunc adapts a reader function to FlowSource
+type FlowSourceFunc func(r io.Reader) ([]FlowData, error)
+
+func (f FlowSourceFunc) ReadFlows(r io.Reader) ([]FlowData, error) {
+	return f(r)
+}
+
+type flowFormat struct {
+	source FlowSource
+	// extensions of files in this format, used when the format is auto
+	extensions []string
+	// detect recognises the format from the first line of a file, optional
+	detect func(firstLine string) bool
+}
+
+// flowFormats are the registered FlowSources, by format name
+var flowFormats = make(map[string]flowFormat)
+
+// registerFlowSource makes a format available to -network-format
+func registerFlowSource(name string, source FlowSource, extensions []string, detect func(firstLine string) bool) {
+	if _, ok := flowFormats[name]; ok {
+		panic(fmt.Sprintf("flow source %s registered twice", name))
+	}
+	flowFormats[name] = flowFormat{
+		source:     source,
+		extensions: extensions,
+		detect:     detect,
+	}
+}
+
+func flowFormatNames() []string {
+	names := make([]string, 0, len(flowFormats))
+	for name := range flowFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readFlowFiles reads and combines the flows of several files of the same
+// format. With the auto format, each file's format is detected from its
+// content, then its extension.
+func readFlowFiles(paths []string, format string) ([]FlowData, error) {
+	if format != autoFlowFormat {
+		if _, ok := flowFormats[format]; !ok {
+			return nil, fmt.Errorf("unknown network format %q, expected one of %s, %s", format, autoFlowFormat, strings.Join(flowFormatNames(), ", "))
+		}
+	}
+
+	flows := []FlowData{}
+	for _, path := range paths {
+		fileFormat := format
+		if fileFormat == autoFlowFormat {
+			var err error
+			if fileFormat, err = detectFlowFormat(path); err != nil {
+				return nil, err
+			}
+		}
+
+		fileFlows, err := readFlowFile(path, flowFormats[fileFormat].source)
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s): %w", path, fileFormat, err)
+		}
+		flows = append(flows, fileFlows...)
+	}
+
+	return flows, nil
+}
+
+func readFlowFile(path string, source FlowSource) ([]FlowData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return source.ReadFlows(file)
+}
+
+func detectFlowFormat(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	firstLine := ""
+	if scanner.Scan() {
+		firstLine = strings.TrimSpace(scanner.Text())
+	}
+
+	for _, name := range flowFormatNames() {
+		if detect := flowFormats[name].detect; detect != nil && detect(firstLine) {
+			return name, nil
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, name := range flowFormatNames() {
+		for _, e := range flowFormats[name].extensions {
+			if ext == e {
+				return name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("unable to detect the format of %s, set -network-format", path)
+}
+
+// fileList is a flag that can be repeated, or hold comma separated values
+type fileList []string
+
+func (l *fileList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *fileList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
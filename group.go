@@ -1,9 +1,210 @@
 package main
 
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultGroup = "other"
+
 var (
 	categorizedNamespaces map[string][]string
+	// grouper maps namespaces to groups, see -groups
+	grouper *namespaceGrouper
 )
 
+// GroupConfig is a user supplied namespace grouping, read from a YAML or JSON
+// file. Its groups are tried in order, before the built-in ones unless
+// Replace is set.
+type GroupConfig struct {
+	Replace bool        `json:"replace"`
+	Groups  []GroupRule `json:"groups"`
+}
+
+// GroupRule puts a namespace in a group when any of its matchers match.
+// Names, patterns and regexes are tried against both the full namespace name
+// and its short form (without 'openshift-').
+type GroupRule struct {
+	Name string `json:"name"`
+	// Namespaces are exact names
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Patterns are shell globs, e.g. "shop-*"
+	Patterns []string `json:"patterns,omitempty"`
+	// Regexes are anchored regular expressions
+	Regexes []string `json:"regexes,omitempty"`
+	// NamespaceSelector matches the labels of the Namespace object
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+type groupMatcher struct {
+	name       string
+	namespaces []string
+	patterns   []string
+	regexes    []*regexp.Regexp
+	selector   labels.Selector
+}
+
+type namespaceGrouper struct {
+	matchers   []groupMatcher
+	namespaces map[string]corev1.Namespace
+	cache      map[string]string
+}
+
+func loadGroupConfig(file string) (GroupConfig, error) {
+	config := GroupConfig{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("unable to decode %s: %w", file, err)
+	}
+	return config, nil
+}
+
+// newNamespaceGrouper compiles the matchers of a config, followed by the
+// built-in groups unless they are replaced
+func newNamespaceGrouper(config GroupConfig) (*namespaceGrouper, error) {
+	g := &namespaceGrouper{
+		namespaces: make(map[string]corev1.Namespace),
+		cache:      make(map[string]string),
+	}
+	for i, r := range config.Groups {
+		if r.Name == "" {
+			return nil, fmt.Errorf("group %d has no name", i)
+		}
+		if r.Name == defaultGroup || r.Name == externalGroup {
+			// the default group catches the namespaces of no group, the
+			// external group holds the endpoints outside the workloads
+			return nil, fmt.Errorf("group %d: %s is a reserved group name", i, r.Name)
+		}
+		m := groupMatcher{
+			name:       r.Name,
+			namespaces: r.Namespaces,
+			patterns:   r.Patterns,
+		}
+		for _, p := range r.Patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("group %s: invalid pattern %q: %w", r.Name, p, err)
+			}
+		}
+		for _, re := range r.Regexes {
+			compiled, err := regexp.Compile("^(?:" + re + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("group %s: invalid regex %q: %w", r.Name, re, err)
+			}
+			m.regexes = append(m.regexes, compiled)
+		}
+		if r.NamespaceSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(r.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: invalid namespaceSelector: %w", r.Name, err)
+			}
+			m.selector = selector
+		}
+		g.matchers = append(g.matchers, m)
+	}
+
+	if !config.Replace {
+		builtin := make([]string, 0, len(categorizedNamespaces))
+		for name := range categorizedNamespaces {
+			builtin = append(builtin, name)
+		}
+		sort.Strings(builtin)
+		for _, name := range builtin {
+			g.matchers = append(g.matchers, groupMatcher{
+				name:       name,
+				namespaces: categorizedNamespaces[name],
+			})
+		}
+	}
+
+	return g, nil
+}
+
+// setNamespaces makes the cluster's Namespace objects available to label
+// selectors
+func (g *namespaceGrouper) setNamespaces(namespaces map[string]corev1.Namespace) {
+	g.namespaces = namespaces
+	g.cache = make(map[string]string)
+}
+
+// group returns the group of a (short) namespace name
+func (g *namespaceGrouper) group(namespace string) string {
+	if group, ok := g.cache[namespace]; ok {
+		return group
+	}
+
+	names := []string{namespace}
+	ns, found := g.namespaces[namespace]
+	if !found {
+		ns, found = g.namespaces["openshift-"+namespace]
+	}
+	if found && ns.Name != namespace {
+		names = append(names, ns.Name)
+	}
+
+	group := defaultGroup
+	for _, m := range g.matchers {
+		if m.matches(names, ns, found) {
+			group = m.name
+			break
+		}
+	}
+
+	g.cache[namespace] = group
+	return group
+}
+
+func (m groupMatcher) matches(names []string, ns corev1.Namespace, found bool) bool {
+	for _, name := range names {
+		if slices.Contains(m.namespaces, name) {
+			return true
+		}
+		for _, p := range m.patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		for _, re := range m.regexes {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return found && m.selector != nil && m.selector.Matches(labels.Set(ns.Labels))
+}
+
+// groupNames lists every group a component can be put in: the groups of
+// namespaces, and the external group of nodes and external addresses
+func (g *namespaceGrouper) groupNames() []string {
+	names := []string{}
+	for _, m := range g.matchers {
+		if !slices.Contains(names, m.name) {
+			names = append(names, m.name)
+		}
+	}
+	for _, name := range []string{defaultGroup, externalGroup} {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func getGroup(namespace string) string {
+	return grouper.group(namespace)
+}
+
 func init() {
 	// high level "group" -> namespace
 	categorizedNamespaces = map[string][]string{
@@ -16,6 +217,8 @@ func init() {
 			"kube-scheduler",
 			"etcd-operator",
 			"etcd",
+			// vanilla kubernetes
+			"kube-system",
 		},
 		"openshift control plane": []string{
 			"apiserver-operator",
@@ -60,6 +263,10 @@ func init() {
 			"service-ca-operator",
 			"service-ca",
 			"ovn-kubernetes",
+			// vanilla kubernetes
+			"ingress-nginx",
+			"calico-system",
+			"tigera-operator",
 		},
 		"observability": {
 			"monitoring",
@@ -73,6 +280,12 @@ func init() {
 		"other": []string{
 			"cluster-samples-operator",
 			"insights",
+			// vanilla kubernetes
+			"kube-public",
+			"kube-node-lease",
 		},
 	}
+
+	// the built-in groups alone can't fail to compile
+	grouper, _ = newNamespaceGrouper(GroupConfig{})
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// hubbleEvent is a line of `hubble observe -o jsonpb`. Fields are decoded
+// from both the proto (snake_case) and the camelCase JSON names.
+type hubbleEvent struct {
+	Flow *hubbleFlow `json:"flow"`
+}
+
+type hubbleFlow struct {
+	Time            string          `json:"time"`
+	Verdict         string          `json:"verdict"`
+	IP              *hubbleIP       `json:"IP"`
+	L4              *hubbleL4       `json:"l4"`
+	Source          *hubbleEndpoint `json:"source"`
+	Destination     *hubbleEndpoint `json:"destination"`
+	NodeName        string          `json:"node_name"`
+	NodeNameCamel   string          `json:"nodeName"`
+	IsReply         *bool           `json:"is_reply"`
+	IsReplyCamel    *bool           `json:"isReply"`
+	DstService      *hubbleService  `json:"destination_service"`
+	DstServiceCamel *hubbleService  `json:"destinationService"`
+}
+
+type hubbleIP struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+type hubbleL4 struct {
+	TCP    *hubblePorts `json:"TCP"`
+	UDP    *hubblePorts `json:"UDP"`
+	SCTP   *hubblePorts `json:"SCTP"`
+	ICMPv4 *struct{}    `json:"ICMPv4"`
+	ICMPv6 *struct{}    `json:"ICMPv6"`
+}
+
+type hubblePorts struct {
+	SourcePort           uint32 `json:"source_port"`
+	SourcePortCamel      uint32 `json:"sourcePort"`
+	DestinationPort      uint32 `json:"destination_port"`
+	DestinationPortCamel uint32 `json:"destinationPort"`
+}
+
+type hubbleEndpoint struct {
+	Namespace    string           `json:"namespace"`
+	Labels       []string         `json:"labels"`
+	PodName      string           `json:"pod_name"`
+	PodNameCamel string           `json:"podName"`
+	Workloads    []hubbleWorkload `json:"workloads"`
+}
+
+type hubbleWorkload struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type hubbleService struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+func init() {
+	registerFlowSource("hubble", FlowSourceFunc(readFlowsHubble), nil, func(firstLine string) bool {
+		var event map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(firstLine), &event); err != nil {
+			return false
+		}
+		_, ok := event["flow"]
+		return ok
+	})
+}
+
+// readFlowsHubble reads Cilium Hubble flows written by `hubble observe -o
+// jsonpb`. Flows without a FORWARDED verdict are skipped, the dependency
+// graph only needs the connections that were made. Replies are kept, marked
+// with FlowData.Reply, to be counted on the connection they answer.
+// Malformed lines are skipped with a warning.
+func readFlowsHubble(r io.Reader) ([]FlowData, error) {
+	var flows []FlowData
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var event hubbleEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			log.Warnf("Skipping malformed flow on line %d: %s", lineNumber, err)
+			continue
+		}
+		if event.Flow == nil {
+			// agent and debug events
+			continue
+		}
+
+		flow, ok := event.Flow.flowData()
+		if ok {
+			flows = append(flows, flow)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(flows) == 0 {
+		return nil, fmt.Errorf("no forwarded flows found")
+	}
+	return flows, nil
+}
+
+func (f *hubbleFlow) flowData() (FlowData, bool) {
+	if f.Verdict != "" && f.Verdict != "FORWARDED" {
+		return FlowData{}, false
+	}
+
+	flow := FlowData{
+		App: "hubble",
+	}
+	if isReply := f.IsReply; isReply != nil || f.IsReplyCamel != nil {
+		if isReply == nil {
+			isReply = f.IsReplyCamel
+		}
+		flow.Reply = strconv.FormatBool(*isReply)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, f.Time); err == nil {
+		flow.TimeFlowStartMs = strconv.FormatInt(t.UnixMilli(), 10)
+		flow.TimeFlowEndMs = flow.TimeFlowStartMs
+	}
+	if f.IP != nil {
+		flow.SrcAddr = f.IP.Source
+		flow.DstAddr = f.IP.Destination
+	}
+	if f.L4 != nil {
+		var ports *hubblePorts
+		switch {
+		case f.L4.TCP != nil:
+			flow.Proto, ports = "6", f.L4.TCP
+		case f.L4.UDP != nil:
+			flow.Proto, ports = "17", f.L4.UDP
+		case f.L4.SCTP != nil:
+			flow.Proto, ports = "132", f.L4.SCTP
+		case f.L4.ICMPv4 != nil:
+			flow.Proto = "1"
+		case f.L4.ICMPv6 != nil:
+			flow.Proto = "58"
+		}
+		if ports != nil {
+			flow.SrcPort = strconv.FormatUint(uint64(ports.SourcePort+ports.SourcePortCamel), 10)
+			flow.DstPort = strconv.FormatUint(uint64(ports.DestinationPort+ports.DestinationPortCamel), 10)
+		}
+	}
+	nodeName := f.NodeName
+	if nodeName == "" {
+		nodeName = f.NodeNameCamel
+	}
+
+	if f.Source != nil {
+		flow.SrcK8S_Namespace = f.Source.Namespace
+		flow.SrcK8S_Name = f.Source.podName()
+		flow.SrcK8S_Type = f.Source.endpointType()
+		flow.SrcK8S_OwnerType, flow.SrcK8S_OwnerName = f.Source.owner()
+	}
+	if f.Destination != nil {
+		flow.DstK8S_Namespace = f.Destination.Namespace
+		flow.DstK8S_Name = f.Destination.podName()
+		flow.DstK8S_Type = f.Destination.endpointType()
+		flow.DstK8S_OwnerType, flow.DstK8S_OwnerName = f.Destination.owner()
+		if flow.DstK8S_Type == "Node" {
+			flow.DstK8S_HostName = nodeName
+		}
+	}
+
+	// traffic to a service that wasn't translated to a backend pod
+	service := f.DstService
+	if service == nil {
+		service = f.DstServiceCamel
+	}
+	if flow.DstK8S_Name == "" && service != nil && service.Name != "" {
+		flow.DstK8S_Namespace = service.Namespace
+		flow.DstK8S_Name = service.Name
+		flow.DstK8S_Type = "Service"
+		flow.DstK8S_OwnerType = "Service"
+		flow.DstK8S_OwnerName = service.Name
+	}
+
+	return flow, true
+}
+
+func (e *hubbleEndpoint) podName() string {
+	if e.PodName != "" {
+		return e.PodName
+	}
+	return e.PodNameCamel
+}
+
+// endpointType is the network observability equivalent of a Cilium identity
+func (e *hubbleEndpoint) endpointType() string {
+	switch {
+	case e.podName() != "":
+		return "Pod"
+	case slices.Contains(e.Labels, "reserved:host") || slices.Contains(e.Labels, "reserved:remote-node"):
+		return "Node"
+	}
+	return ""
+}
+
+// owner is the first workload of the endpoint. Older Hubble versions don't
+// report workloads, these are resolved from the cluster's pods later on.
+func (e *hubbleEndpoint) owner() (string, string) {
+	for _, w := range e.Workloads {
+		if w.Name != "" {
+			return strings.TrimSpace(w.Kind), w.Name
+		}
+	}
+	return "", ""
+}
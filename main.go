@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
 
 	routev1 "github.com/openshift/api/route/v1"
+	securityv1 "github.com/openshift/api/security/v1"
 	routeclientv1 "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
+	securityclientv1 "github.com/openshift/client-go/security/clientset/versioned/typed/security/v1"
 	"github.com/sfowl/pod-checker/pkg/helpers"
 	"github.com/sfowl/pod-checker/pkg/sachecker"
 	"github.com/sfowl/pod-checker/pkg/sslchecker"
@@ -20,8 +23,14 @@ import (
 	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	//
 	// Uncomment to load all auth plugins
@@ -46,18 +55,25 @@ type ClusterData struct {
 	ServicesByNamespace map[string][]corev1.Service
 	ReplicaSets         []appsv1.ReplicaSet
 	Routes              []routev1.Route
-}
-
-// XXX this is super inefficient, lazy. Maybe should invert the map.
-func getGroup(namespace string) string {
-	for g, namespaces := range categorizedNamespaces {
-		for _, n := range namespaces {
-			if namespace == n {
-				return g
-			}
-		}
-	}
-	return "other"
+	Ingresses           []networkingv1.Ingress
+	HTTPRoutes          []unstructured.Unstructured
+	NetworkPolicies     []networkingv1.NetworkPolicy
+	// AdminNetworkPolicies are policy.networking.k8s.io objects, where served
+	AdminNetworkPolicies []unstructured.Unstructured
+	// APIVersions are the versions HTTPRoutes and AdminNetworkPolicies were
+	// listed with, by API group
+	APIVersions     map[string]string
+	ServiceAccounts []corev1.ServiceAccount
+	// Secrets are service account token secrets, without their data
+	Secrets             []corev1.Secret
+	Roles               []rbacv1.Role
+	ClusterRoles        []rbacv1.ClusterRole
+	RoleBindings        []rbacv1.RoleBinding
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding
+	// SecurityContextConstraints are only served by OpenShift
+	SecurityContextConstraints []securityv1.SecurityContextConstraints
+	Platform                   Platform
+	Status                     []CollectionStatus
 }
 
 func printValues(writer *csv.Writer, values []string) {
@@ -79,7 +95,8 @@ func getSecurityInfo(p corev1.Pod) (string, ComponentSecurityContext) {
 	}
 	for _, c := range p.Spec.Containers {
 		if c.SecurityContext != nil {
-			if c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+			// SCCs only exist on OpenShift
+			if platform == PlatformOpenShift && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
 				scc = "privileged"
 			}
 			securityContext.updateFromContainerSC(c.SecurityContext)
@@ -111,6 +128,32 @@ func getDeployedNodes(p corev1.Pod, ownerKind string) string {
 	return runsOn
 }
 
+// getPodOwner returns the kind and name of the workload a pod belongs to,
+// following ReplicaSets up to their Deployment. Pods owned by a Node are
+// static pods.
+func getPodOwner(p corev1.Pod, replicaSets []appsv1.ReplicaSet) (string, string) {
+	if len(p.OwnerReferences) == 0 {
+		return "", ""
+	}
+	owner := p.OwnerReferences[0]
+	ownerKind := owner.Kind
+	ownerName := owner.Name
+	if owner.Kind == "ReplicaSet" {
+		for _, r := range replicaSets {
+			if r.Namespace == p.Namespace && r.Name == owner.Name && len(r.OwnerReferences) > 0 {
+				ownerName = r.OwnerReferences[0].Name
+				ownerKind = r.OwnerReferences[0].Kind
+			}
+		}
+	}
+	if ownerKind == "Node" {
+		ownerKind = "StaticPods"
+		ownerName = platform.staticPodName(p)
+	}
+
+	return ownerKind, ownerName
+}
+
 func probePortMatches(probe *corev1.Probe, servPort int) bool {
 	if probe != nil && probe.HTTPGet != nil && probe.HTTPGet.Port.IntValue() == servPort {
 		return true
@@ -145,6 +188,10 @@ func getServices(pod corev1.Pod, services map[string][]corev1.Service) []corev1.
 		if strings.Contains(s.Name, "metrics") {
 			continue
 		}
+		if len(s.Spec.Selector) == 0 {
+			// endpoints are managed by hand, the service selects no pods
+			continue
+		}
 
 		for k, v := range s.Spec.Selector {
 			if pod.Labels[k] != v {
@@ -192,23 +239,140 @@ func getRoutes(service corev1.Service, routeList []routev1.Route) []routev1.Rout
 	return matching
 }
 
-func getClusterData() ClusterData {
+// getIngresses with a backend pointing at a given service
+func getIngresses(service corev1.Service, ingressList []networkingv1.Ingress) []networkingv1.Ingress {
+	matching := []networkingv1.Ingress{}
+	for _, i := range ingressList {
+		if i.Namespace != service.Namespace {
+			continue
+		}
+
+		backends := []networkingv1.IngressBackend{}
+		if i.Spec.DefaultBackend != nil {
+			backends = append(backends, *i.Spec.DefaultBackend)
+		}
+		for _, rule := range i.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				backends = append(backends, path.Backend)
+			}
+		}
+
+		for _, b := range backends {
+			if b.Service != nil && b.Service.Name == service.Name {
+				matching = append(matching, i)
+				break
+			}
+		}
+	}
+
+	return matching
+}
+
+// getHTTPRoutes are Gateway API HTTPRoutes with a backendRef pointing at a
+// given service
+func getHTTPRoutes(service corev1.Service, httpRoutes []unstructured.Unstructured) []unstructured.Unstructured {
+	matching := []unstructured.Unstructured{}
+	for _, r := range httpRoutes {
+		rules, _, _ := unstructured.NestedSlice(r.Object, "spec", "rules")
+		matched := false
+		for _, rule := range rules {
+			ruleMap, ok := rule.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			backendRefs, _, _ := unstructured.NestedSlice(ruleMap, "backendRefs")
+			for _, ref := range backendRefs {
+				refMap, ok := ref.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				kind, found, _ := unstructured.NestedString(refMap, "kind")
+				if found && kind != "Service" {
+					continue
+				}
+				namespace, found, _ := unstructured.NestedString(refMap, "namespace")
+				if !found {
+					namespace = r.GetNamespace()
+				}
+				name, _, _ := unstructured.NestedString(refMap, "name")
+				if namespace == service.Namespace && name == service.Name {
+					matched = true
+				}
+			}
+		}
+		if matched {
+			matching = append(matching, r)
+		}
+	}
+
+	return matching
+}
+
+// serviceAccountName is the service account of a pod, default when not set
+func serviceAccountName(p corev1.Pod) string {
+	if p.Spec.ServiceAccountName != "" {
+		return p.Spec.ServiceAccountName
+	}
+	if p.Spec.DeprecatedServiceAccount != "" {
+		return p.Spec.DeprecatedServiceAccount
+	}
+	return "default"
+}
+
+// loadKubeConfig loads the client configuration the way kubectl does, from
+// $KUBECONFIG, ~/.kube/config or the in-cluster service account
+func loadKubeConfig() (*rest.Config, error) {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	configOverrides := &clientcmd.ConfigOverrides{}
 
 	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
 	if err != nil {
-		panic(err.Error())
+		return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
+	return config, nil
+}
+
+// getClusterData lists the resources needed for the analysis from the API
+// server. Only pods are required, other resource types that can't be listed
+// (e.g. forbidden, or an API not served by the cluster) are recorded in
+// ClusterData.Status and skipped.
+func getClusterData() (ClusterData, error) {
+	config, err := loadKubeConfig()
+	if err != nil {
+		return ClusterData{}, err
 	}
 
 	// create the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		return ClusterData{}, err
 	}
 	routev1Client, err := routeclientv1.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		return ClusterData{}, err
+	}
+	securityv1Client, err := securityclientv1.NewForConfig(config)
+	if err != nil {
+		return ClusterData{}, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return ClusterData{}, err
+	}
+
+	clusterData := ClusterData{
+		Namespaces:          make(map[string]corev1.Namespace),
+		ServicesByNamespace: make(map[string][]corev1.Service),
+		APIVersions:         make(map[string]string),
+	}
+
+	// API group -> preferred version, nil when discovery failed
+	served, err := servedGroups(clientset.Discovery())
+	if err != nil {
+		clusterData.missing(newCollectionError("apigroups", err))
 	}
 
 	pods, err := clientset.CoreV1().Pods("").List(
@@ -218,51 +382,171 @@ func getClusterData() ClusterData {
 		},
 	)
 	if err != nil {
-		panic(err.Error())
+		return clusterData, newCollectionError("pods", err)
 	}
+	clusterData.Pods = pods.Items
+	clusterData.collected("pods", len(pods.Items))
+
 	rs, err := clientset.AppsV1().ReplicaSets("").List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		panic(err.Error())
+		clusterData.missing(newCollectionError("replicasets", err))
+	} else {
+		clusterData.ReplicaSets = rs.Items
+		clusterData.collected("replicasets", len(rs.Items))
 	}
 
 	ns, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		panic(err.Error())
-	}
-	namespaces := make(map[string]corev1.Namespace)
-	for _, n := range ns.Items {
-		namespaces[n.Name] = n
+		clusterData.missing(newCollectionError("namespaces", err))
+	} else {
+		clusterData.Namespaces = namespacesByName(ns.Items)
+		clusterData.collected("namespaces", len(ns.Items))
 	}
 
 	svcs, err := clientset.CoreV1().Services("").List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		panic(err.Error())
+		clusterData.missing(newCollectionError("services", err))
+	} else {
+		clusterData.ServicesByNamespace = servicesByNamespace(svcs.Items)
+		clusterData.collected("services", len(svcs.Items))
 	}
-	services := make(map[string][]corev1.Service)
-	for _, s := range svcs.Items {
-		if _, ok := services[s.Namespace]; ok {
-			services[s.Namespace] = append(services[s.Namespace], s)
+
+	if served != nil && served[routeAPIGroup] == "" {
+		clusterData.missing(&CollectionError{
+			Resource: "routes",
+			Reason:   metav1.StatusReasonNotFound,
+			Err:      fmt.Errorf("%s API not served", routeAPIGroup),
+		})
+	} else {
+		routes, err := routev1Client.Routes("").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			clusterData.missing(newCollectionError("routes", err))
 		} else {
-			services[s.Namespace] = []corev1.Service{s}
+			clusterData.Routes = routes.Items
+			clusterData.collected("routes", len(routes.Items))
 		}
 	}
 
-	routes, err := routev1Client.Routes("").List(context.TODO(), metav1.ListOptions{})
+	ingresses, err := clientset.NetworkingV1().Ingresses("").List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		panic(err.Error())
+		clusterData.missing(newCollectionError("ingresses", err))
+	} else {
+		clusterData.Ingresses = ingresses.Items
+		clusterData.collected("ingresses", len(ingresses.Items))
+	}
+
+	if version := served[gatewayAPIGroup]; version != "" {
+		gvr := schema.GroupVersionResource{Group: gatewayAPIGroup, Version: version, Resource: "httproutes"}
+		httpRoutes, err := dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			clusterData.missing(newCollectionError("httproutes", err))
+		} else {
+			clusterData.HTTPRoutes = httpRoutes.Items
+			clusterData.APIVersions[gatewayAPIGroup] = version
+			clusterData.collected("httproutes", len(httpRoutes.Items))
+		}
+	}
+
+	networkPolicies, err := clientset.NetworkingV1().NetworkPolicies("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		clusterData.missing(newCollectionError("networkpolicies", err))
+	} else {
+		clusterData.NetworkPolicies = networkPolicies.Items
+		clusterData.collected("networkpolicies", len(networkPolicies.Items))
+	}
+
+	if version := served[adminNetworkPolicyAPIGroup]; version != "" {
+		gvr := schema.GroupVersionResource{Group: adminNetworkPolicyAPIGroup, Version: version, Resource: "adminnetworkpolicies"}
+		anps, err := dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			clusterData.missing(newCollectionError("adminnetworkpolicies", err))
+		} else {
+			clusterData.AdminNetworkPolicies = anps.Items
+			clusterData.APIVersions[adminNetworkPolicyAPIGroup] = version
+			clusterData.collected("adminnetworkpolicies", len(anps.Items))
+		}
+	}
+
+	serviceAccounts, err := clientset.CoreV1().ServiceAccounts("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		clusterData.missing(newCollectionError("serviceaccounts", err))
+	} else {
+		clusterData.ServiceAccounts = serviceAccounts.Items
+		clusterData.collected("serviceaccounts", len(serviceAccounts.Items))
+	}
+
+	secrets, err := clientset.CoreV1().Secrets("").List(
+		context.TODO(),
+		metav1.ListOptions{
+			FieldSelector: "type=" + string(corev1.SecretTypeServiceAccountToken),
+		},
+	)
+	if err != nil {
+		clusterData.missing(newCollectionError("secrets", err))
+	} else {
+		clusterData.Secrets = tokenSecretsMetadata(secrets.Items)
+		clusterData.collected("secrets", len(clusterData.Secrets))
+	}
+
+	roles, err := clientset.RbacV1().Roles("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		clusterData.missing(newCollectionError("roles", err))
+	} else {
+		clusterData.Roles = roles.Items
+		clusterData.collected("roles", len(roles.Items))
+	}
+
+	clusterRoles, err := clientset.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		clusterData.missing(newCollectionError("clusterroles", err))
+	} else {
+		clusterData.ClusterRoles = clusterRoles.Items
+		clusterData.collected("clusterroles", len(clusterRoles.Items))
+	}
+
+	roleBindings, err := clientset.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		clusterData.missing(newCollectionError("rolebindings", err))
+	} else {
+		clusterData.RoleBindings = roleBindings.Items
+		clusterData.collected("rolebindings", len(roleBindings.Items))
+	}
+
+	clusterRoleBindings, err := clientset.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		clusterData.missing(newCollectionError("clusterrolebindings", err))
+	} else {
+		clusterData.ClusterRoleBindings = clusterRoleBindings.Items
+		clusterData.collected("clusterrolebindings", len(clusterRoleBindings.Items))
+	}
+
+	if served != nil && served[securityAPIGroup] == "" {
+		clusterData.missing(&CollectionError{
+			Resource: "securitycontextconstraints",
+			Reason:   metav1.StatusReasonNotFound,
+			Err:      fmt.Errorf("%s API not served", securityAPIGroup),
+		})
+	} else {
+		sccs, err := securityv1Client.SecurityContextConstraints().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			clusterData.missing(newCollectionError("securitycontextconstraints", err))
+		} else {
+			clusterData.SecurityContextConstraints = sccs.Items
+			clusterData.collected("securitycontextconstraints", len(sccs.Items))
+		}
+	}
+
+	if served != nil {
+		clusterData.Platform = detectPlatform(served)
+	} else {
+		clusterData.Platform = inferPlatform(clusterData)
 	}
 	// deploys, err := clientset.AppsV1().Deployments("").List(context.TODO(), metav1.ListOptions{})
 	// if err != nil {
 	// 	panic(err.Error())
 	// }
 
-	return ClusterData{
-		Namespaces:          namespaces,
-		Pods:                pods.Items,
-		ReplicaSets:         rs.Items,
-		Routes:              routes.Items,
-		ServicesByNamespace: services,
-	}
+	return clusterData, nil
 }
 
 func getComponentKey(namespace string, ownerType string, ownerName string) string {
@@ -274,24 +558,39 @@ func getComponentKey(namespace string, ownerType string, ownerName string) strin
 		}
 	}
 
-	group := getGroup(strings.TrimPrefix(namespace, "openshift-"))
-	componentKey := strings.Join([]string{group, namespace, ownerType, ownerName}, "/")
-
-	// XXX special case
-	if componentKey == "other/default/Service/kubernetes" {
-		componentKey = "kube control plane/kube-apiserver/StaticPods/kube-apiserver"
+	// XXX special case, the kubernetes service is backed by the apiserver static pods
+	if namespace == "default" && ownerType == "Service" && ownerName == "kubernetes" {
+		return getComponentKey(platform.apiServerNamespace(), "StaticPods", "kube-apiserver")
 	}
 
+	group := getGroup(namespace)
+	componentKey := strings.Join([]string{group, namespace, ownerType, ownerName}, "/")
+
 	return componentKey
 }
 
 func main() {
 	log.SetLevel(log.DebugLevel)
 
-	networkCSV := flag.String("network-csv", "", "Path to the CSV file")
+	var networkFiles fileList
+	flag.Var(&networkFiles, "network", "Path to a file of network flows, can be repeated or comma separated")
+	networkFormat := flag.String("network-format", autoFlowFormat, fmt.Sprintf("Format of the -network files: %s, %s", autoFlowFormat, strings.Join(flowFormatNames(), ", ")))
+	networkCSV := flag.String("network-csv", "", "Path to the CSV file, same as -network <file> -network-format csv")
+	externalCIDRs := flag.String("external-cidrs", "", "Path to a YAML or JSON file naming the CIDRs of endpoints outside the cluster")
+	threatDragon := flag.String("threat-dragon", "", "Write a Threat Dragon model of the components to the given file")
+	generateNetpol := flag.String("generate-netpol", "", "Write NetworkPolicies allowing the observed traffic to the given directory, one file per namespace")
+	flag.BoolVar(&guessReplyFlows, "guess-replies", false, "Count flows from a well known port to an ephemeral one as replies when the exporter doesn't tell replies apart")
+	resolveExternal := flag.Bool("resolve-external", false, "Name external flow endpoints with reverse DNS")
 	exclude := flag.String("exclude", "", "list of groups to exclude (comma separated)")
 	checkSsl := flag.Bool("check-ssl", false, "Enable SSL verification for each of the services mapped to the pods")
-	checkSA := flag.Bool("check-sa", false, "Enable verifications for the service accounts bound to each pod (RBAC and tokens)")
+	checkSA := flag.Bool("check-sa", false, "Deprecated, service accounts are always analysed")
+	snapshot := flag.String("snapshot", "", "Read cluster data from a snapshot directory or .tar.gz archive instead of the API server")
+	mustGather := flag.String("must-gather", "", "Read cluster data from an OpenShift must-gather directory or .tar.gz archive instead of the API server")
+	dump := flag.String("dump", "", "Write a snapshot of the cluster data to the given directory and exit")
+	platformName := flag.String("platform", "", "Cluster platform, openshift or kubernetes (detected when not set)")
+	groups := flag.String("groups", "", "Path to a YAML or JSON file grouping namespaces, extending or replacing the built-in groups")
+	var ruleFiles fileList
+	flag.Var(&ruleFiles, "rules", "Path to a YAML file of custom CEL rules, can be repeated or comma separated")
 	flag.Parse()
 
 	// if *networkCSV == "" {
@@ -299,9 +598,89 @@ func main() {
 	// 	os.Exit(1)
 	// }
 
+	if *groups != "" {
+		config, err := loadGroupConfig(*groups)
+		if err != nil {
+			log.Fatalf("Unable to load groups: %s", err)
+		}
+		if grouper, err = newNamespaceGrouper(config); err != nil {
+			log.Fatalf("Invalid groups in %s: %s", *groups, err)
+		}
+	}
+
 	excludedGroups := strings.Split(*exclude, ",")
+	for _, g := range excludedGroups {
+		if g != "" && !slices.Contains(grouper.groupNames(), g) {
+			log.Warnf("Excluded group %q is not a known group", g)
+		}
+	}
+
+	rules := builtinRules
+	if len(ruleFiles) > 0 {
+		customRules, err := loadRuleFiles(ruleFiles)
+		if err != nil {
+			log.Fatalf("Unable to load rules: %s", err)
+		}
+		log.Infof("Loaded %d custom rules", len(customRules))
+		rules = append(slices.Clip(rules), customRules...)
+	}
+
+	if *checkSA {
+		log.Warn("-check-sa is deprecated, the RBAC and tokens of service accounts are always analysed")
+	}
+
+	if *snapshot != "" && *mustGather != "" {
+		log.Fatal("-snapshot and -must-gather are mutually exclusive")
+	}
+
+	var clusterData ClusterData
+	var err error
+	switch {
+	case *snapshot != "":
+		if clusterData, err = loadSnapshot(*snapshot); err != nil {
+			log.Fatalf("Unable to load snapshot %s: %s", *snapshot, err)
+		}
+	case *mustGather != "":
+		if clusterData, err = loadMustGather(*mustGather); err != nil {
+			log.Fatalf("Unable to load must-gather %s: %s", *mustGather, err)
+		}
+	default:
+		if clusterData, err = getClusterData(); err != nil {
+			log.Fatalf("Unable to get cluster data: %s", err)
+		}
+	}
+
+	if *platformName != "" {
+		if clusterData.Platform, err = parsePlatform(*platformName); err != nil {
+			log.Fatal(err)
+		}
+	} else if clusterData.Platform == "" {
+		clusterData.Platform = inferPlatform(clusterData)
+	}
+	platform = clusterData.Platform
+	grouper.setNamespaces(clusterData.Namespaces)
+	log.Debugf("Analysing %s cluster", platform)
 
-	clusterData := getClusterData()
+	if *dump != "" {
+		if err := dumpSnapshot(clusterData, *dump); err != nil {
+			log.Fatalf("Unable to write snapshot to %s: %s", *dump, err)
+		}
+		log.Infof("Cluster data written to %s", *dump)
+		return
+	}
+
+	// interrupting stops the port forwards of the checks before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+
+	// the TLS of services is probed through port forwards to their pods
+	var restConfig *rest.Config
+	if *checkSsl {
+		if *snapshot != "" || *mustGather != "" {
+			log.Warn("Can't check SSL of services without access to the cluster")
+		} else if restConfig, err = loadKubeConfig(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	serviceToComponent := make(map[string]string)
 	components := make(map[string]Component)
@@ -317,31 +696,14 @@ func main() {
 		}
 
 		if len(p.OwnerReferences) != 1 {
-			panic(fmt.Sprintf("Expected one owner, got %d", len(p.OwnerReferences)))
-		}
-		owner := p.OwnerReferences[0]
-		ownerKey := ""
-		ownerKind := owner.Kind
-		ownerName := owner.Name
-		if owner.Kind == "ReplicaSet" {
-			for _, r := range clusterData.ReplicaSets {
-				if r.Name == owner.Name {
-					ownerName = r.OwnerReferences[0].Name
-					ownerKind = r.OwnerReferences[0].Kind
-				}
-			}
-		}
-		if ownerKind == "Node" {
-			name := strings.TrimPrefix(p.Labels["app"], "openshift-")
-			ownerKey = fmt.Sprintf("StaticPods/%s", name)
-			ownerKind = "StaticPods"
-			ownerName = name
-		} else {
-			ownerKey = fmt.Sprintf("%s/%s", ownerKind, ownerName)
+			log.Warnf("Skipping pod %s/%s: expected one owner, got %d", p.Namespace, p.Name, len(p.OwnerReferences))
+			continue
 		}
+		ownerKind, ownerName := getPodOwner(p, clusterData.ReplicaSets)
+		ownerKey := fmt.Sprintf("%s/%s", ownerKind, ownerName)
 
-		namespace := strings.TrimPrefix(p.GetNamespace(), "openshift-")
-		group := getGroup(strings.TrimPrefix(namespace, "openshift-"))
+		namespace := platform.shortNamespace(p.GetNamespace())
+		group := getGroup(namespace)
 		if slices.Contains(excludedGroups, group) {
 			log.Debugf("Skipping %s due to exclusions", ownerKey)
 			continue
@@ -354,19 +716,23 @@ func main() {
 		var ok bool
 		if c, ok = components[componentKey]; !ok {
 			c = Component{
-				Name: ownerName,
-				// everything starts with 'openshift-', noisy
-				Namespace:   strings.TrimPrefix(p.Namespace, "openshift-"),
-				Group:       group,
-				DeployedAs:  ownerKind,
-				HostNetwork: p.Spec.HostNetwork,
-				HostMounts:  hostMounts,
-				RunLevel:    runLevel,
-				Pods:        []corev1.Pod{p},
+				Name:             ownerName,
+				Namespace:        namespace,
+				Group:            group,
+				DeployedAs:       ownerKind,
+				HostNetwork:      p.Spec.HostNetwork,
+				HostMounts:       hostMounts,
+				RunLevel:         runLevel,
+				PodSecurityLevel: podSecurityLevel(clusterData.Namespaces[p.Namespace]),
+				Pods:             []corev1.Pod{p},
 			}
 		} else {
 			c.Pods = append(c.Pods, p)
-			c.HostMounts = append(hostMounts)
+			for _, m := range hostMounts {
+				if !slices.Contains(c.HostMounts, m) {
+					c.HostMounts = append(c.HostMounts, m)
+				}
+			}
 		}
 
 		runsOn := getDeployedNodes(p, ownerKind)
@@ -374,8 +740,10 @@ func main() {
 		podServices := getServices(p, clusterData.ServicesByNamespace)
 		for _, s := range podServices {
 			serviceToComponent[fmt.Sprintf("%s/%s/Service/%s", group, namespace, s.Name)] = componentKey
-			if *checkSsl {
-				sslchecker.SslCheckerForServices(p.GetNamespace(), s.Name, s.Spec.Ports, group)
+		}
+		if restConfig != nil && len(podServices) > 0 {
+			if err := sslchecker.SslCheckerForServices(ctx, restConfig, p, podServices, group); err != nil {
+				log.Fatalf("SSL checks interrupted: %s", err)
 			}
 		}
 
@@ -401,33 +769,138 @@ func main() {
 		}
 		if len(podServices) > 0 {
 			c.InboundTraffic = true
-			// c.Services = append(c.Services, podServices)
 			for _, ps := range podServices {
+				if slices.ContainsFunc(c.Services, func(s corev1.Service) bool { return s.Name == ps.Name }) {
+					// already seen on another pod of the component
+					continue
+				}
+				c.Services = append(c.Services, ps)
+				c.ServiceDetails = append(c.ServiceDetails, newServiceInfo(ps))
+
+				c.ExposedBy = appendUnique(c.ExposedBy, serviceExposure(ps)...)
+
 				serviceRoutes := getRoutes(ps, clusterData.Routes)
-				if len(serviceRoutes) > 0 {
-					c.ExternallyExposed = true
-					// c.Routes = append(c.Routes, serviceRoutes)
+				c.Routes = append(c.Routes, serviceRoutes...)
+				for _, r := range serviceRoutes {
+					info := newRouteInfo(r)
+					info.TargetPort = routeTargetPort(r, ps, c.Pods)
+					c.RouteDetails = append(c.RouteDetails, info)
+				}
+				for _, i := range getIngresses(ps, clusterData.Ingresses) {
+					c.RouteDetails = append(c.RouteDetails, newIngressRouteInfos(i, ps.Name)...)
 				}
+				for _, r := range getHTTPRoutes(ps, clusterData.HTTPRoutes) {
+					c.RouteDetails = append(c.RouteDetails, newHTTPRouteInfos(r, ps.Name)...)
+				}
+			}
+			for _, r := range c.RouteDetails {
+				c.ExposedBy = appendUnique(c.ExposedBy, r.Kind)
 			}
+			c.InsecureExposures = plainHTTPFindings(c.RouteDetails)
+		}
+		if hasHostPorts(p) {
+			c.ExposedBy = appendUnique(c.ExposedBy, exposedByHostPort)
 		}
+		c.ExternallyExposed = len(c.ExposedBy) > 0
 
 		components[componentKey] = c
+	}
+
+	// back to the default handling of interrupts
+	stop()
+
+	for k, c := range components {
+		namespace, name := c.Pods[0].Namespace, serviceAccountName(c.Pods[0])
+		var sa *corev1.ServiceAccount
+		if clusterData.isCollected("serviceaccounts") {
+			sa = serviceAccount(clusterData.ServiceAccounts, namespace, name)
+		}
+		secrets := namespaceSecrets(clusterData.Secrets, clusterData.isCollected("secrets"), namespace)
+		c.ServiceAccountTokens = sachecker.TokensFor(c.Pods, name, sa, secrets)
+
+		c.PodSecurity = evaluatePodSecurity(c.Pods, namespace, clusterData.Namespaces)
+		components[k] = c
+	}
 
-		if *checkSA && p.Spec.ServiceAccountName != "" {
-			r := sachecker.NewSAChecker(p.GetNamespace(), p.Spec.ServiceAccountName, group)
-			r.Run()
+	if clusterData.isCollected("roles") && clusterData.isCollected("clusterroles") &&
+		clusterData.isCollected("rolebindings") && clusterData.isCollected("clusterrolebindings") {
+		rbac := sachecker.NewRBAC(clusterData.Roles, clusterData.ClusterRoles, clusterData.RoleBindings, clusterData.ClusterRoleBindings)
+		for k, c := range components {
+			c.RBAC = rbac.Permissions(c.Pods[0].Namespace, serviceAccountName(c.Pods[0]))
+			components[k] = c
 		}
 	}
 
-	if *networkCSV != "" {
-		flowData := getNetworkTraffic(networkCSV)
-		components = addNetworkDataToComponents(components, serviceToComponent, flowData)
+	if clusterData.isCollected("securitycontextconstraints") {
+		sccs := []SCCInfo{}
+		for _, scc := range clusterData.SecurityContextConstraints {
+			sccs = append(sccs, newSCCInfo(scc))
+		}
+		sortSCCs(sccs)
+		writeYAML(marshalYAML(sccs), "example/output/sccs.yaml")
+
+		for k, c := range components {
+			c.SCCDetails = componentSCC(c, clusterData.SecurityContextConstraints, c.Pods[0].Namespace, clusterData.Namespaces, c.RBAC)
+			components[k] = c
+		}
+	}
+
+	var policies *networkPolicies
+	if clusterData.isCollected("networkpolicies") {
+		policies = newNetworkPolicies(clusterData)
+		for k, c := range components {
+			c.NetworkPolicy = policies.forPods(c.Pods)
+			components[k] = c
+		}
+	}
+
+	if len(networkFiles) > 0 || *networkCSV != "" {
+		flowData, err := readFlowFiles(networkFiles, *networkFormat)
+		if err != nil {
+			log.Fatalf("Unable to read network traffic: %s", err)
+		}
+		if *networkCSV != "" {
+			flows, err := readFlowFiles([]string{*networkCSV}, "csv")
+			if err != nil {
+				log.Fatalf("Unable to read network traffic: %s", err)
+			}
+			flowData = append(flowData, flows...)
+		}
+		var networks []ExternalNetwork
+		if *externalCIDRs != "" {
+			if networks, err = loadExternalNetworks(*externalCIDRs); err != nil {
+				log.Fatalf("Unable to load external CIDRs: %s", err)
+			}
+		}
+		external, err := newExternalEndpoints(networks, *resolveExternal)
+		if err != nil {
+			log.Fatalf("Invalid external CIDRs in %s: %s", *externalCIDRs, err)
+		}
+		resolveFlowOwners(flowData, clusterData)
+		components = addNetworkDataToComponents(components, serviceToComponent, flowData, external, excludedGroups)
+
+		if policies != nil {
+			report := compareFlowsWithPolicies(policies, flowData, clusterData, serviceToComponent, external)
+			log.Infof("%d observed flows denied by network policies, %d unused policy rules", len(report.DeniedFlows), len(report.UnusedRules))
+			writeYAML(marshalYAML(report), "example/output/network_policy_report.yaml")
+		}
+		if *generateNetpol != "" {
+			if err := writeNetworkPolicies(generateNetworkPolicies(components), *generateNetpol); err != nil {
+				log.Fatalf("Unable to write network policies to %s: %s", *generateNetpol, err)
+			}
+		}
 	} else {
 		log.Warn("Can't generate a threat model diagram without network data")
+		if *generateNetpol != "" {
+			log.Warn("Can't generate network policies without network data")
+		}
 	}
 
 	// components := filterComponents(components, excludedGroups)
 
+	statusYAML := marshalYAML(clusterData.Status)
+	writeYAML(statusYAML, "example/output/collection_status.yaml")
+
 	printCSV(components, "example/output/components.tsv")
 	// fmt.Printf("\nThere are %d pods\n", numPods)
 
@@ -440,12 +913,22 @@ func main() {
 	// also write one component per file
 	writeComponents(components, "example/output/components")
 
+	findings := evaluateRules(components, rules)
+	log.Infof("%d findings", len(findings))
+	if err := writeFindings(findings, "example/output/findings.yaml", "example/output/findings.json"); err != nil {
+		log.Errorf("Unable to write findings: %s", err)
+	}
+
 	survey := genSurvey(components)
 	surveyYAML := marshalYAML(survey)
 	writeYAML(surveyYAML, "example/output/survey.yaml")
 	writeSurvey(components, "example/output/survey")
 
-	// generateThreatModel(components, "example/output/threat_dragon.json", excludedGroups)
+	if *threatDragon != "" {
+		if err := generateThreatModel(components, *threatDragon, excludedGroups); err != nil {
+			log.Fatalf("Unable to write the threat model to %s: %s", *threatDragon, err)
+		}
+	}
 }
 
 // func filterComponents(components map[string]Component, excludedGroups []string) {
@@ -463,6 +946,9 @@ func writeComponents(components map[string]Component, outputDir string) {
 
 func writeSurvey(components map[string]Component, outputDir string) {
 	for k, c := range components {
+		if c.IsExternal() {
+			continue
+		}
 		survey := genSurvey(map[string]Component{k: c})
 		surveyYAML := marshalYAML(survey[0])
 		dir := fmt.Sprintf("%s/%s", outputDir, helpers.CanonicalGroup(c.Group))
@@ -512,6 +998,9 @@ func printCSV(components map[string]Component, outputFile string) {
 		"RunsOn",
 		"IsOperator",
 		"Default SCC",
+		"SCCs",
+		"PodSecurityLevel",
+		"PodSecurity",
 		"RunLevel",
 		"HostIPC",
 		"HostNetwork",
@@ -530,8 +1019,15 @@ func printCSV(components map[string]Component, outputFile string) {
 		"PriorityClass",
 		"InboundTraffic?",
 		"ExternallyExposed?",
+		"ExposedBy",
+		"NetworkPolicyIsolation",
+		"NetworkPolicies",
 		"IncomingConnections",
 		"OutgoingConnections",
+		"Services",
+		"Routes",
+		"RBACFlags",
+		"ServiceAccountTokens",
 		"HostMounts",
 	})
 	for _, k := range keys {
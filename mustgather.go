@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	routev1 "github.com/openshift/api/route/v1"
+	securityv1 "github.com/openshift/api/security/v1"
+	"github.com/sfowl/pod-checker/pkg/helpers"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// loadMustGather reads ClusterData from an OpenShift must-gather directory or
+// .tar.gz archive. Resources are looked up in the per-namespace layout
+// written by must-gather, wherever it sits below path:
+//
+//	namespaces/<ns>/<ns>.yaml
+//	namespaces/<ns>/core/pods.yaml
+//	namespaces/<ns>/core/services.yaml
+//	namespaces/<ns>/apps/replicasets.yaml
+//	namespaces/<ns>/route.openshift.io/routes.yaml
+//	namespaces/<ns>/networking.k8s.io/ingresses.yaml
+//	namespaces/<ns>/networking.k8s.io/networkpolicies.yaml
+//	namespaces/<ns>/rbac.authorization.k8s.io/roles.yaml
+//	namespaces/<ns>/rbac.authorization.k8s.io/rolebindings.yaml
+//	cluster-scoped-resources/rbac.authorization.k8s.io/clusterroles/<name>.yaml
+//	cluster-scoped-resources/rbac.authorization.k8s.io/clusterrolebindings/<name>.yaml
+//	cluster-scoped-resources/security.openshift.io/securitycontextconstraints/<name>.yaml
+//
+// When core/pods.yaml is absent, the individual namespaces/<ns>/pods/<pod>/<pod>.yaml
+// files are used instead.
+func loadMustGather(path string) (ClusterData, error) {
+	dir := path
+	if helpers.IsArchive(path) {
+		tmpDir, err := helpers.ExtractArchive(path)
+		if err != nil {
+			return ClusterData{}, err
+		}
+		defer os.RemoveAll(tmpDir)
+		dir = tmpDir
+	}
+
+	var namespaces []corev1.Namespace
+	var pods []corev1.Pod
+	var replicaSets []appsv1.ReplicaSet
+	var services []corev1.Service
+	var routes []routev1.Route
+	var ingresses []networkingv1.Ingress
+	var networkPolicies []networkingv1.NetworkPolicy
+	var roles []rbacv1.Role
+	var roleBindings []rbacv1.RoleBinding
+	var clusterRoles []rbacv1.ClusterRole
+	var clusterRoleBindings []rbacv1.ClusterRoleBinding
+	var sccs []securityv1.SecurityContextConstraints
+	// namespaces with a core/pods.yaml, single pod files are redundant there
+	podLists := make(map[string]bool)
+	// resource types with at least one file, the others weren't gathered
+	gathered := make(map[string]bool)
+	podFiles := make(map[string][]string)
+
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(file, ".yaml") {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(file), "/")
+		n := len(parts)
+		base := parts[n-1]
+
+		switch {
+		case n >= 3 && parts[n-3] == "namespaces" && base == parts[n-2]+".yaml":
+			var ns corev1.Namespace
+			if err := decodeMustGatherFile(file, &ns); err != nil {
+				return err
+			}
+			namespaces = append(namespaces, ns)
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == "core" && base == "pods.yaml":
+			var list corev1.PodList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			podLists[parts[n-3]] = true
+			pods = append(pods, list.Items...)
+		case n >= 5 && parts[n-5] == "namespaces" && parts[n-3] == "pods" && base == parts[n-2]+".yaml":
+			podFiles[parts[n-4]] = append(podFiles[parts[n-4]], file)
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == "core" && base == "services.yaml":
+			var list corev1.ServiceList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			services = append(services, list.Items...)
+			gathered["services"] = true
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == "apps" && base == "replicasets.yaml":
+			var list appsv1.ReplicaSetList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			replicaSets = append(replicaSets, list.Items...)
+			gathered["replicasets"] = true
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == "route.openshift.io" && base == "routes.yaml":
+			var list routev1.RouteList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			routes = append(routes, list.Items...)
+			gathered["routes"] = true
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == "networking.k8s.io" && base == "ingresses.yaml":
+			var list networkingv1.IngressList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			ingresses = append(ingresses, list.Items...)
+			gathered["ingresses"] = true
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == "networking.k8s.io" && base == "networkpolicies.yaml":
+			var list networkingv1.NetworkPolicyList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			networkPolicies = append(networkPolicies, list.Items...)
+			gathered["networkpolicies"] = true
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == rbacv1.GroupName && base == "roles.yaml":
+			var list rbacv1.RoleList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			roles = append(roles, list.Items...)
+			gathered["roles"] = true
+		case n >= 4 && parts[n-4] == "namespaces" && parts[n-2] == rbacv1.GroupName && base == "rolebindings.yaml":
+			var list rbacv1.RoleBindingList
+			if err := decodeMustGatherFile(file, &list); err != nil {
+				return err
+			}
+			roleBindings = append(roleBindings, list.Items...)
+			gathered["rolebindings"] = true
+		case n >= 4 && parts[n-4] == "cluster-scoped-resources" && parts[n-3] == rbacv1.GroupName && parts[n-2] == "clusterroles":
+			var role rbacv1.ClusterRole
+			if err := decodeMustGatherFile(file, &role); err != nil {
+				return err
+			}
+			clusterRoles = append(clusterRoles, role)
+			gathered["clusterroles"] = true
+		case n >= 4 && parts[n-4] == "cluster-scoped-resources" && parts[n-3] == rbacv1.GroupName && parts[n-2] == "clusterrolebindings":
+			var binding rbacv1.ClusterRoleBinding
+			if err := decodeMustGatherFile(file, &binding); err != nil {
+				return err
+			}
+			clusterRoleBindings = append(clusterRoleBindings, binding)
+			gathered["clusterrolebindings"] = true
+		case n >= 4 && parts[n-4] == "cluster-scoped-resources" && parts[n-3] == securityAPIGroup && parts[n-2] == "securitycontextconstraints":
+			var scc securityv1.SecurityContextConstraints
+			if err := decodeMustGatherFile(file, &scc); err != nil {
+				return err
+			}
+			sccs = append(sccs, scc)
+			gathered["securitycontextconstraints"] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return ClusterData{}, err
+	}
+
+	for ns, files := range podFiles {
+		if podLists[ns] {
+			continue
+		}
+		for _, file := range files {
+			var p corev1.Pod
+			if err := decodeMustGatherFile(file, &p); err != nil {
+				return ClusterData{}, err
+			}
+			pods = append(pods, p)
+		}
+	}
+
+	if len(namespaces) == 0 && len(pods) == 0 {
+		return ClusterData{}, fmt.Errorf("no namespaces found in must-gather %s", path)
+	}
+
+	// the API server is queried for running pods only, do the same here
+	running := make([]corev1.Pod, 0, len(pods))
+	for _, p := range pods {
+		if p.Status.Phase == corev1.PodRunning {
+			running = append(running, p)
+		}
+	}
+	log.Debugf("Read %d namespaces and %d running pods from must-gather %s", len(namespaces), len(running), path)
+
+	clusterData := ClusterData{
+		Namespaces:                 namespacesByName(namespaces),
+		Pods:                       running,
+		ReplicaSets:                replicaSets,
+		Routes:                     routes,
+		Ingresses:                  ingresses,
+		NetworkPolicies:            networkPolicies,
+		Roles:                      roles,
+		RoleBindings:               roleBindings,
+		ClusterRoles:               clusterRoles,
+		ClusterRoleBindings:        clusterRoleBindings,
+		ServicesByNamespace:        servicesByNamespace(services),
+		SecurityContextConstraints: sccs,
+		Platform:                   PlatformOpenShift,
+	}
+	clusterData.collected("pods", len(running))
+	clusterData.collected("namespaces", len(namespaces))
+	// the default must-gather doesn't include cluster scoped RBAC or SCCs,
+	// and namespaced resources are only gathered for some namespaces
+	for _, r := range []struct {
+		resource string
+		count    int
+	}{
+		{"replicasets", len(replicaSets)},
+		{"services", len(services)},
+		{"routes", len(routes)},
+		{"ingresses", len(ingresses)},
+		{"networkpolicies", len(networkPolicies)},
+		{"roles", len(roles)},
+		{"rolebindings", len(roleBindings)},
+		{"clusterroles", len(clusterRoles)},
+		{"clusterrolebindings", len(clusterRoleBindings)},
+		{"securitycontextconstraints", len(sccs)},
+	} {
+		if gathered[r.resource] {
+			clusterData.collected(r.resource, r.count)
+			continue
+		}
+		clusterData.missing(&CollectionError{
+			Resource: r.resource,
+			Reason:   metav1.StatusReasonNotFound,
+			Err:      fmt.Errorf("no %s in must-gather %s", r.resource, path),
+		})
+	}
+
+	return clusterData, nil
+}
+
+func decodeMustGatherFile(file string, into interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, into); err != nil {
+		return fmt.Errorf("unable to decode %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lokiResponse is the body of a Loki query_range (or query) API call on the
+// network observability flow logs
+type lokiResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func init() {
+	registerFlowSource("netobserv-json", FlowSourceFunc(readFlowsNetObservJSON), []string{".json", ".jsonl", ".ndjson"}, func(firstLine string) bool {
+		return strings.HasPrefix(firstLine, "{") && strings.Contains(firstLine, `"SrcK8S_`)
+	})
+}
+
+// readFlowsNetObservJSON reads network observability flow records as stored
+// in Loki. The input is either a Loki query_range response, a JSON array of
+// flow records, or one flow record per line (malformed lines are skipped
+// with a warning).
+func readFlowsNetObservJSON(r io.Reader) ([]FlowData, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no flows found")
+	}
+
+	if data[0] == '[' {
+		var records []map[string]interface{}
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+		flows := make([]FlowData, 0, len(records))
+		for _, record := range records {
+			flows = append(flows, flowFromRecord(record, nil))
+		}
+		return flows, nil
+	}
+
+	var loki lokiResponse
+	if err := json.Unmarshal(data, &loki); err == nil && loki.Status != "" {
+		return readFlowsLoki(loki)
+	}
+
+	var flows []FlowData
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	// flow records can be long
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		record, err := decodeFlowRecord(line)
+		if err != nil {
+			log.Warnf("Skipping malformed flow on line %d: %s", lineNumber, err)
+			continue
+		}
+		flows = append(flows, flowFromRecord(record, nil))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(flows) == 0 {
+		return nil, fmt.Errorf("no flows found")
+	}
+	return flows, nil
+}
+
+func readFlowsLoki(loki lokiResponse) ([]FlowData, error) {
+	if loki.Status != "success" {
+		return nil, fmt.Errorf("loki query status is %q", loki.Status)
+	}
+	if loki.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("expected a streams result, got %q", loki.Data.ResultType)
+	}
+
+	var flows []FlowData
+	for i, stream := range loki.Data.Result {
+		for j, value := range stream.Values {
+			record, err := decodeFlowRecord([]byte(value[1]))
+			if err != nil {
+				log.Warnf("Skipping malformed flow %d of stream %d: %s", j, i, err)
+				continue
+			}
+			flows = append(flows, flowFromRecord(record, stream.Stream))
+		}
+	}
+
+	return flows, nil
+}
+
+func decodeFlowRecord(data []byte) (map[string]interface{}, error) {
+	var record map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// keep ports, bytes and timestamps as written
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// flowFromRecord maps a flow record to FlowData. Loki stream labels, when
+// given, are used for fields missing in the record.
+func flowFromRecord(record map[string]interface{}, labels map[string]string) FlowData {
+	flow := FlowData{}
+	for name, value := range labels {
+		if field, ok := flowField(name); ok {
+			*flowFields[field](&flow) = value
+		}
+	}
+
+	for name, value := range record {
+		field, ok := flowField(name)
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			*flowFields[field](&flow) = v
+		case json.Number:
+			*flowFields[field](&flow) = v.String()
+		case float64:
+			*flowFields[field](&flow) = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			*flowFields[field](&flow) = strconv.FormatBool(v)
+		}
+	}
+
+	return flow
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// apiServerPort is where the API server listens, on the host network
+const apiServerPort = 6443
+
+// podTemplateLabels change with every rollout, or are unique to a pod, they
+// can't select the pods of a component
+var podTemplateLabels = []string{
+	"pod-template-hash",
+	"controller-revision-hash",
+	"pod-template-generation",
+	"statefulset.kubernetes.io/pod-name",
+	"apps.kubernetes.io/pod-index",
+}
+
+// generateNetworkPolicies builds one NetworkPolicy per component allowing
+// the observed connections only, plus DNS and API server egress. Policies
+// are returned by namespace.
+func generateNetworkPolicies(components map[string]Component) map[string][]networkingv1.NetworkPolicy {
+	policies := make(map[string][]networkingv1.NetworkPolicy)
+
+	keys := make([]string, 0, len(components))
+	for k := range components {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		c := components[k]
+		if c.IsExternal() || len(c.Pods) == 0 || c.HostNetwork {
+			// network policies don't apply to host network pods
+			continue
+		}
+		namespace := c.Pods[0].Namespace
+		labels := podLabels(c.Pods)
+		if len(labels) == 0 {
+			// an empty podSelector would select every pod of the namespace
+			log.Warnf("Not generating a network policy for %s: its pods share no labels", k)
+			continue
+		}
+
+		policy := networkingv1.NetworkPolicy{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "networking.k8s.io/v1",
+				Kind:       "NetworkPolicy",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      networkPolicyName(c),
+				Namespace: namespace,
+				Annotations: map[string]string{
+					"pod-checker/component": k,
+				},
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: labels},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+				Ingress:     []networkingv1.NetworkPolicyIngressRule{},
+				Egress:      dnsAndAPIServerEgress(),
+			},
+		}
+		for _, conn := range c.IncomingConnections {
+			peers, ok := connectionPeers(components, conn, namespace)
+			if !ok {
+				continue
+			}
+			ports, ok := connectionPorts(conn)
+			if !ok {
+				log.Warnf("Not allowing the connection from %s to %s: %s can't be expressed in a NetworkPolicy", conn.Component, k, strings.Join(conn.Ports, ", "))
+				continue
+			}
+			policy.Spec.Ingress = append(policy.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+				From:  peers,
+				Ports: ports,
+			})
+		}
+		for _, conn := range c.OutgoingConnections {
+			peers, ok := connectionPeers(components, conn, namespace)
+			if !ok {
+				continue
+			}
+			ports, ok := connectionPorts(conn)
+			if !ok {
+				log.Warnf("Not allowing the connection from %s to %s: %s can't be expressed in a NetworkPolicy", k, conn.Component, strings.Join(conn.Ports, ", "))
+				continue
+			}
+			policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{
+				To:    peers,
+				Ports: ports,
+			})
+		}
+
+		policies[namespace] = append(policies[namespace], policy)
+	}
+
+	return policies
+}
+
+// networkPolicyName is <owner kind>-<owner name>-observed-traffic, as a
+// valid DNS subdomain name
+func networkPolicyName(c Component) string {
+	const suffix = "-observed-traffic"
+
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(c.DeployedAs+"-"+c.Name))
+	if len(name) > validation.DNS1123SubdomainMaxLength-len(suffix) {
+		name = name[:validation.DNS1123SubdomainMaxLength-len(suffix)]
+	}
+	return strings.Trim(name, "-.") + suffix
+}
+
+// writeNetworkPolicies writes the policies of each namespace to
+// <dir>/<namespace>.yaml
+func writeNetworkPolicies(policies map[string][]networkingv1.NetworkPolicy, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for namespace, nsPolicies := range policies {
+		var b strings.Builder
+		b.WriteString("# Generated by pod-checker from observed traffic, review before applying\n")
+		for _, p := range nsPolicies {
+			data, err := yaml.Marshal(p)
+			if err != nil {
+				return err
+			}
+			b.WriteString("---\n")
+			b.Write(data)
+		}
+		if err := os.WriteFile(filepath.Join(dir, namespace+".yaml"), []byte(b.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// podLabels are the labels shared by all the pods of a component, except
+// the ones set per pod or per rollout
+func podLabels(pods []corev1.Pod) map[string]string {
+	shared := make(map[string]string)
+	for k, v := range pods[0].Labels {
+		shared[k] = v
+	}
+	for _, p := range pods[1:] {
+		for k, v := range shared {
+			if p.Labels[k] != v {
+				delete(shared, k)
+			}
+		}
+	}
+	for _, l := range podTemplateLabels {
+		delete(shared, l)
+	}
+	return shared
+}
+
+// connectionPeers selects the peer of a connection: its pods, or its
+// addresses when it's outside the pod network
+func connectionPeers(components map[string]Component, conn Connection, namespace string) ([]networkingv1.NetworkPolicyPeer, bool) {
+	peer, ok := components[conn.Component]
+	if !ok {
+		return nil, false
+	}
+
+	if peer.IsExternal() || peer.HostNetwork {
+		addresses := peer.Addresses
+		if !peer.IsExternal() {
+			addresses = podAddresses(peer.Pods)
+		}
+		peers := []networkingv1.NetworkPolicyPeer{}
+		for _, a := range addresses {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: hostCIDR(a)},
+			})
+		}
+		return peers, len(peers) > 0
+	}
+
+	labels := podLabels(peer.Pods)
+	if len(labels) == 0 {
+		// would select every pod
+		return nil, false
+	}
+	p := networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{MatchLabels: labels},
+	}
+	if peerNamespace := peer.Pods[0].Namespace; peerNamespace != namespace {
+		p.NamespaceSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{"kubernetes.io/metadata.name": peerNamespace},
+		}
+	}
+	return []networkingv1.NetworkPolicyPeer{p}, true
+}
+
+// connectionPorts converts the protocol/port of a connection, protocols
+// without ports (e.g. ICMP) can't be expressed in a NetworkPolicy. It's not
+// ok when none of the ports of the connection could be converted, as a rule
+// without ports would allow all of them.
+func connectionPorts(conn Connection) ([]networkingv1.NetworkPolicyPort, bool) {
+	ports := []networkingv1.NetworkPolicyPort{}
+	for _, p := range conn.Ports {
+		protocol, port, found := strings.Cut(p, "/")
+		number, err := strconv.Atoi(port)
+		if !found || err != nil {
+			continue
+		}
+		switch corev1.Protocol(protocol) {
+		case corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+			ports = append(ports, networkPolicyPort(corev1.Protocol(protocol), number))
+		}
+	}
+	return ports, len(ports) > 0 || len(conn.Ports) == 0
+}
+
+// dnsAndAPIServerEgress allows the cluster DNS, and the API server. The API
+// server runs on the host network, so it can only be matched by its port.
+func dnsAndAPIServerEgress() []networkingv1.NetworkPolicyEgressRule {
+	namespace, labels, port := platform.dnsPods()
+	return []networkingv1.NetworkPolicyEgressRule{
+		{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"kubernetes.io/metadata.name": namespace},
+				},
+				PodSelector: &metav1.LabelSelector{MatchLabels: labels},
+			}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				networkPolicyPort(corev1.ProtocolUDP, port),
+				networkPolicyPort(corev1.ProtocolTCP, port),
+			},
+		},
+		{
+			Ports: []networkingv1.NetworkPolicyPort{
+				networkPolicyPort(corev1.ProtocolTCP, apiServerPort),
+			},
+		},
+	}
+}
+
+func networkPolicyPort(protocol corev1.Protocol, port int) networkingv1.NetworkPolicyPort {
+	p := intstr.FromInt(port)
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &p}
+}
+
+func podAddresses(pods []corev1.Pod) []string {
+	addresses := []string{}
+	for _, p := range pods {
+		if p.Status.PodIP != "" {
+			addresses = appendUnique(addresses, p.Status.PodIP)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// hostCIDR is the single address CIDR of an IPv4 or IPv6 address
+func hostCIDR(address string) string {
+	if strings.Contains(address, ":") {
+		return fmt.Sprintf("%s/128", address)
+	}
+	return fmt.Sprintf("%s/32", address)
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
-	"os"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type FlowData struct {
+	TimeFlowStartMs  string
+	TimeFlowEndMs    string
+	TimeReceived     string
 	DstK8S_OwnerName string
 	FlowDirection    string
 	SrcK8S_Namespace string
@@ -40,103 +45,219 @@ type FlowData struct {
 	DstK8S_HostIP    string
 	SrcAddr          string
 	SrcK8S_Name      string
+	// Reply is "true" when the exporter reports the flow as a reply to a
+	// connection, "false" when it reports it as a request, empty otherwise
+	Reply string
 }
 
-func getNetworkTraffic(networkCSV *string) []FlowData {
-	file, err := os.Open(*networkCSV)
-	if err != nil {
-		log.Fatal(err)
+// flowFields maps the field names used by the network observability
+// operator to FlowData
+var flowFields = map[string]func(f *FlowData) *string{
+	"TimeFlowStartMs":  func(f *FlowData) *string { return &f.TimeFlowStartMs },
+	"TimeFlowEndMs":    func(f *FlowData) *string { return &f.TimeFlowEndMs },
+	"TimeReceived":     func(f *FlowData) *string { return &f.TimeReceived },
+	"DstK8S_OwnerName": func(f *FlowData) *string { return &f.DstK8S_OwnerName },
+	"FlowDirection":    func(f *FlowData) *string { return &f.FlowDirection },
+	"SrcK8S_Namespace": func(f *FlowData) *string { return &f.SrcK8S_Namespace },
+	"SrcK8S_OwnerName": func(f *FlowData) *string { return &f.SrcK8S_OwnerName },
+	"app":              func(f *FlowData) *string { return &f.App },
+	"DstK8S_Namespace": func(f *FlowData) *string { return &f.DstK8S_Namespace },
+	"AgentIP":          func(f *FlowData) *string { return &f.AgentIP },
+	"DstPort":          func(f *FlowData) *string { return &f.DstPort },
+	"Etype":            func(f *FlowData) *string { return &f.Etype },
+	"SrcMac":           func(f *FlowData) *string { return &f.SrcMac },
+	"Proto":            func(f *FlowData) *string { return &f.Proto },
+	"Bytes":            func(f *FlowData) *string { return &f.Bytes },
+	"DstK8S_Name":      func(f *FlowData) *string { return &f.DstK8S_Name },
+	"DstK8S_OwnerType": func(f *FlowData) *string { return &f.DstK8S_OwnerType },
+	"SrcK8S_Type":      func(f *FlowData) *string { return &f.SrcK8S_Type },
+	"DstAddr":          func(f *FlowData) *string { return &f.DstAddr },
+	"Duplicate":        func(f *FlowData) *string { return &f.Duplicate },
+	"DstMac":           func(f *FlowData) *string { return &f.DstMac },
+	"SrcK8S_OwnerType": func(f *FlowData) *string { return &f.SrcK8S_OwnerType },
+	"DstK8S_Type":      func(f *FlowData) *string { return &f.DstK8S_Type },
+	"Packets":          func(f *FlowData) *string { return &f.Packets },
+	"SrcPort":          func(f *FlowData) *string { return &f.SrcPort },
+	"DstK8S_HostName":  func(f *FlowData) *string { return &f.DstK8S_HostName },
+	"Interface":        func(f *FlowData) *string { return &f.Interface },
+	"Flags":            func(f *FlowData) *string { return &f.Flags },
+	"IfDirection":      func(f *FlowData) *string { return &f.IfDirection },
+	"DstK8S_HostIP":    func(f *FlowData) *string { return &f.DstK8S_HostIP },
+	"SrcAddr":          func(f *FlowData) *string { return &f.SrcAddr },
+	"SrcK8S_Name":      func(f *FlowData) *string { return &f.SrcK8S_Name },
+}
+
+// requiredFlowFields are needed to map a flow to its components
+var requiredFlowFields = []string{
+	"SrcK8S_Namespace",
+	"SrcK8S_OwnerName",
+	"SrcK8S_OwnerType",
+	"DstK8S_Namespace",
+	"DstK8S_OwnerName",
+	"DstK8S_OwnerType",
+}
+
+// flowField looks up a field name, ignoring case
+func flowField(name string) (string, bool) {
+	if _, ok := flowFields[name]; ok {
+		return name, true
 	}
-	defer file.Close()
+	for f := range flowFields {
+		if strings.EqualFold(f, name) {
+			return f, true
+		}
+	}
+	return "", false
+}
 
-	reader := csv.NewReader(file)
+func init() {
+	registerFlowSource("csv", FlowSourceFunc(readFlowsCSV), []string{".csv"}, func(firstLine string) bool {
+		// a header row
+		return !strings.HasPrefix(firstLine, "{") && strings.Contains(firstLine, ",") && strings.Contains(firstLine, "SrcK8S_")
+	})
+}
 
-	var flows []FlowData
+// readFlowsCSV reads a CSV export of the network observability console. Its
+// columns are mapped by the header row, unknown columns are ignored and
+// malformed rows are skipped with a warning.
+func readFlowsCSV(r io.Reader) ([]FlowData, error) {
+	reader := csv.NewReader(r)
+	// row lengths are checked against the header below
+	reader.FieldsPerRecord = -1
 
-	// Skip first line
-	if _, err := reader.Read(); err != nil {
-		log.Fatal(err)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty CSV, expected a header row")
+	} else if err != nil {
+		return nil, err
 	}
 
+	columns := make(map[int]string)
+	for i, name := range header {
+		if field, ok := flowField(strings.TrimSpace(name)); ok {
+			columns[i] = field
+		}
+	}
+	missing := []string{}
+	for _, required := range requiredFlowFields {
+		if !slices.Contains(maps.Values(columns), required) {
+			missing = append(missing, required)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required columns: %s", strings.Join(missing, ", "))
+	}
+
+	var flows []FlowData
+	malformed := 0
 	for {
 		line, err := reader.Read()
 		if err == io.EOF {
 			break
+		} else if parseErr, ok := err.(*csv.ParseError); ok {
+			log.Warnf("Skipping malformed flow on line %d: %s", parseErr.StartLine, parseErr.Err)
+			malformed++
+			continue
 		} else if err != nil {
-			log.Fatal(err)
+			return nil, err
+		}
+
+		if len(line) != len(header) {
+			lineNumber, _ := reader.FieldPos(0)
+			log.Warnf("Skipping malformed flow on line %d: expected %d fields, got %d", lineNumber, len(header), len(line))
+			malformed++
+			continue
 		}
 
-		flow := FlowData{
-			DstK8S_OwnerName: line[3],
-			FlowDirection:    line[4],
-			SrcK8S_Namespace: line[5],
-			SrcK8S_OwnerName: line[6],
-			App:              line[7],
-			DstK8S_Namespace: line[8],
-			AgentIP:          line[9],
-			DstPort:          line[10],
-			Etype:            line[11],
-			SrcMac:           line[12],
-			Proto:            line[13],
-			Bytes:            line[14],
-			DstK8S_Name:      line[15],
-			DstK8S_OwnerType: line[16],
-			SrcK8S_Type:      line[17],
-			DstAddr:          line[18],
-			Duplicate:        line[19],
-			DstMac:           line[20],
-			SrcK8S_OwnerType: line[21],
-			DstK8S_Type:      line[22],
-			Packets:          line[23],
-			SrcPort:          line[24],
-			DstK8S_HostName:  line[25],
-			Interface:        line[26],
-			Flags:            line[27],
-			IfDirection:      line[28],
-			DstK8S_HostIP:    line[29],
-			SrcAddr:          line[30],
-			SrcK8S_Name:      line[31],
+		flow := FlowData{}
+		for i, field := range columns {
+			*flowFields[field](&flow) = line[i]
 		}
 		flows = append(flows, flow)
 	}
 
-	return flows
+	if len(flows) == 0 && malformed > 0 {
+		return nil, fmt.Errorf("all %d flows are malformed", malformed)
+	}
+
+	return flows, nil
 }
 
-func addNetworkDataToComponents(components map[string]Component, serviceToComponent map[string]string, flows []FlowData) map[string]Component {
+// resolveFlowOwners fills in the owner of flow endpoints that only name their
+// pod (e.g. Hubble flows without workloads), using the cluster's pods
+func resolveFlowOwners(flows []FlowData, clusterData ClusterData) {
+	pods := make(map[string]corev1.Pod)
+	for _, p := range clusterData.Pods {
+		pods[p.Namespace+"/"+p.Name] = p
+	}
+
+	resolve := func(namespace string, name string, kind *string, ownerType *string, ownerName *string) {
+		if *ownerName != "" || name == "" || (*kind != "" && *kind != "Pod") {
+			return
+		}
+		p, ok := pods[namespace+"/"+name]
+		if !ok {
+			return
+		}
+		*kind = "Pod"
+		*ownerType, *ownerName = getPodOwner(p, clusterData.ReplicaSets)
+	}
+
+	for i := range flows {
+		f := &flows[i]
+		resolve(f.SrcK8S_Namespace, f.SrcK8S_Name, &f.SrcK8S_Type, &f.SrcK8S_OwnerType, &f.SrcK8S_OwnerName)
+		resolve(f.DstK8S_Namespace, f.DstK8S_Name, &f.DstK8S_Type, &f.DstK8S_OwnerType, &f.DstK8S_OwnerName)
+	}
+}
+
+// addNetworkDataToComponents aggregates the flows into connections between
+// components. Replies are counted on the connection they answer, so that
+// connections go from client to server and carry the server's port.
+// Endpoints that are nodes or external addresses become synthetic components
+// of the external group, the flows to them are dropped when the group is
+// excluded.
+func addNetworkDataToComponents(components map[string]Component, serviceToComponent map[string]string, flows []FlowData, external *externalEndpoints, excludedGroups []string) map[string]Component {
+	excludeExternal := slices.Contains(excludedGroups, externalGroup)
 	for _, f := range flows {
-		srcNamespace := strings.TrimPrefix(f.SrcK8S_Namespace, "openshift-")
-		srcComponentKey := getComponentKey(srcNamespace, f.SrcK8S_OwnerType, f.SrcK8S_OwnerName)
+		if isReplyFlow(f) {
+			f = reverseFlow(f)
+		}
+
+		srcComponentKey := getComponentKey(platform.shortNamespace(f.SrcK8S_Namespace), f.SrcK8S_OwnerType, f.SrcK8S_OwnerName)
 		if v, ok := serviceToComponent[srcComponentKey]; ok {
 			srcComponentKey = v
+		} else if c, ok := external.component(f.SrcK8S_Type, f.SrcK8S_OwnerType, f.SrcK8S_OwnerName, f.SrcK8S_Name, "", f.SrcAddr); ok {
+			if excludeExternal {
+				continue
+			}
+			srcComponentKey = addExternalComponent(components, c, f.SrcAddr)
 		}
 
-		dstNamespace := strings.TrimPrefix(f.DstK8S_Namespace, "openshift-")
-		dstComponentKey := getComponentKey(dstNamespace, f.DstK8S_OwnerType, f.DstK8S_OwnerName)
+		dstComponentKey := getComponentKey(platform.shortNamespace(f.DstK8S_Namespace), f.DstK8S_OwnerType, f.DstK8S_OwnerName)
 		if v, ok := serviceToComponent[dstComponentKey]; ok {
 			dstComponentKey = v
+		} else if c, ok := external.component(f.DstK8S_Type, f.DstK8S_OwnerType, f.DstK8S_OwnerName, f.DstK8S_Name, f.DstK8S_HostName, f.DstAddr); ok {
+			if excludeExternal {
+				continue
+			}
+			dstComponentKey = addExternalComponent(components, c, f.DstAddr)
 		}
 
 		if srcComponent, ok := components[srcComponentKey]; !ok {
 			log.Warnf("Unknown src component %s\n", srcComponentKey)
 			// os.Exit(1)
 		} else {
-			// fmt.Println("adding", dstComponentKey, "to", srcComponentKey)
-			if !slices.Contains(srcComponent.OutgoingConnections, dstComponentKey) {
-				srcComponent.OutgoingConnections = append(srcComponent.OutgoingConnections, dstComponentKey)
-				components[srcComponentKey] = srcComponent
-			}
+			srcComponent.OutgoingConnections = addConnection(srcComponent.OutgoingConnections, dstComponentKey, f)
+			components[srcComponentKey] = srcComponent
 		}
 
 		if dstComponent, ok := components[dstComponentKey]; !ok {
 			log.Warnf("Unknown dst component %s\n", dstComponentKey)
 			// os.Exit(1)
 		} else {
-			if !slices.Contains(dstComponent.IncomingConnections, srcComponentKey) {
-				dstComponent.IncomingConnections = append(dstComponent.IncomingConnections, srcComponentKey)
-				dstComponent.InboundTraffic = true
-				components[dstComponentKey] = dstComponent
-			}
+			dstComponent.IncomingConnections = addConnection(dstComponent.IncomingConnections, srcComponentKey, f)
+			dstComponent.InboundTraffic = true
+			components[dstComponentKey] = dstComponent
 		}
 	}
 
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ComponentNetworkPolicy is the effect of the network policies selecting a
+// component's pods. A direction is isolated when every pod is selected by a
+// NetworkPolicy of that type: only the allowed peers can then connect.
+type ComponentNetworkPolicy struct {
+	// Policies select the pods, as namespace/name for NetworkPolicies and
+	// AdminNetworkPolicy/name for AdminNetworkPolicies
+	Policies        []string `yaml:"policies"`
+	IngressIsolated bool     `yaml:"ingressIsolated"`
+	EgressIsolated  bool     `yaml:"egressIsolated"`
+	AllowedIngress  []string `yaml:"allowedIngress,omitempty"`
+	AllowedEgress   []string `yaml:"allowedEgress,omitempty"`
+	// AdminRules are the AdminNetworkPolicy rules applying to the pods, by
+	// priority. These take precedence over NetworkPolicies.
+	AdminRules []string `yaml:"adminRules,omitempty"`
+}
+
+// Isolation names the isolated directions, e.g. "ingress and egress"
+func (p *ComponentNetworkPolicy) Isolation() string {
+	switch {
+	case p == nil:
+		return "unknown"
+	case p.IngressIsolated && p.EgressIsolated:
+		return "ingress and egress"
+	case p.IngressIsolated:
+		return "ingress"
+	case p.EgressIsolated:
+		return "egress"
+	}
+	return "no"
+}
+
+func (p *ComponentNetworkPolicy) policies() []string {
+	if p == nil {
+		return nil
+	}
+	return p.Policies
+}
+
+// adminNetworkPolicy is the part of a policy.networking.k8s.io
+// AdminNetworkPolicy used here, decoded from unstructured data to avoid
+// depending on the network-policy-api module
+type adminNetworkPolicy struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Priority int32                    `json:"priority"`
+		Subject  adminNetworkPolicyPeer   `json:"subject"`
+		Ingress  []adminNetworkPolicyRule `json:"ingress"`
+		Egress   []adminNetworkPolicyRule `json:"egress"`
+	} `json:"spec"`
+}
+
+type adminNetworkPolicyRule struct {
+	Name   string                   `json:"name"`
+	Action string                   `json:"action"`
+	From   []adminNetworkPolicyPeer `json:"from"`
+	To     []adminNetworkPolicyPeer `json:"to"`
+	Ports  []adminNetworkPolicyPort `json:"ports"`
+}
+
+// adminNetworkPolicyPeer is a subject or peer, one field is set
+type adminNetworkPolicyPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *struct {
+		NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+		PodSelector       metav1.LabelSelector `json:"podSelector"`
+	} `json:"pods,omitempty"`
+	Nodes    *metav1.LabelSelector `json:"nodes,omitempty"`
+	Networks []string              `json:"networks,omitempty"`
+}
+
+type adminNetworkPolicyPort struct {
+	PortNumber *struct {
+		Protocol corev1.Protocol `json:"protocol"`
+		Port     int32           `json:"port"`
+	} `json:"portNumber,omitempty"`
+	NamedPort *string `json:"namedPort,omitempty"`
+	PortRange *struct {
+		Protocol corev1.Protocol `json:"protocol"`
+		Start    int32           `json:"start"`
+		End      int32           `json:"end"`
+	} `json:"portRange,omitempty"`
+}
+
+// networkPolicies evaluates the cluster's network policies against pods
+type networkPolicies struct {
+	byNamespace map[string][]networkingv1.NetworkPolicy
+	admin       []adminNetworkPolicy
+	namespaces  map[string]corev1.Namespace
+}
+
+func newNetworkPolicies(clusterData ClusterData) *networkPolicies {
+	n := &networkPolicies{
+		byNamespace: make(map[string][]networkingv1.NetworkPolicy),
+		namespaces:  clusterData.Namespaces,
+	}
+	for _, p := range clusterData.NetworkPolicies {
+		n.byNamespace[p.Namespace] = append(n.byNamespace[p.Namespace], p)
+	}
+	for _, u := range clusterData.AdminNetworkPolicies {
+		var anp adminNetworkPolicy
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &anp); err != nil {
+			log.Warnf("Skipping AdminNetworkPolicy %s: %s", u.GetName(), err)
+			continue
+		}
+		n.admin = append(n.admin, anp)
+	}
+	sort.SliceStable(n.admin, func(i, j int) bool {
+		return n.admin[i].Spec.Priority < n.admin[j].Spec.Priority
+	})
+	return n
+}
+
+// namespaceLabels of a namespace, including the name label set by the API
+// server that policies commonly select on
+func (n *networkPolicies) namespaceLabels(namespace string) labels.Set {
+	set := labels.Set{}
+	for k, v := range n.namespaces[namespace].Labels {
+		set[k] = v
+	}
+	set["kubernetes.io/metadata.name"] = namespace
+	return set
+}
+
+// selecting are the NetworkPolicies selecting a pod
+func (n *networkPolicies) selecting(pod corev1.Pod) []networkingv1.NetworkPolicy {
+	var selecting []networkingv1.NetworkPolicy
+	for _, p := range n.byNamespace[pod.Namespace] {
+		if selectorMatches(&p.Spec.PodSelector, pod.Labels) {
+			selecting = append(selecting, p)
+		}
+	}
+	return selecting
+}
+
+// adminSelecting are the AdminNetworkPolicies whose subject is a pod, by
+// priority
+func (n *networkPolicies) adminSelecting(pod corev1.Pod) []adminNetworkPolicy {
+	var selecting []adminNetworkPolicy
+	for _, p := range n.admin {
+		if n.peerMatchesPod(p.Spec.Subject, pod) {
+			selecting = append(selecting, p)
+		}
+	}
+	return selecting
+}
+
+func (n *networkPolicies) peerMatchesPod(peer adminNetworkPolicyPeer, pod corev1.Pod) bool {
+	switch {
+	case peer.Namespaces != nil:
+		return selectorMatches(peer.Namespaces, n.namespaceLabels(pod.Namespace))
+	case peer.Pods != nil:
+		return selectorMatches(&peer.Pods.NamespaceSelector, n.namespaceLabels(pod.Namespace)) &&
+			selectorMatches(&peer.Pods.PodSelector, pod.Labels)
+	}
+	return false
+}
+
+// forPods merges the effect of the policies on all the pods of a component.
+// Policies don't apply to host network pods, which are never isolated.
+func (n *networkPolicies) forPods(pods []corev1.Pod) *ComponentNetworkPolicy {
+	result := &ComponentNetworkPolicy{
+		Policies:        []string{},
+		IngressIsolated: len(pods) > 0,
+		EgressIsolated:  len(pods) > 0,
+	}
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork {
+			result.IngressIsolated, result.EgressIsolated = false, false
+			continue
+		}
+		ingressIsolated, egressIsolated := false, false
+		for _, p := range n.selecting(pod) {
+			result.Policies = appendUnique(result.Policies, p.Namespace+"/"+p.Name)
+			ingress, egress := policyTypes(p)
+			if ingress {
+				ingressIsolated = true
+				for _, r := range p.Spec.Ingress {
+					result.AllowedIngress = appendUnique(result.AllowedIngress, describePolicyRule(r.From, r.Ports, p.Namespace)...)
+				}
+			}
+			if egress {
+				egressIsolated = true
+				for _, r := range p.Spec.Egress {
+					result.AllowedEgress = appendUnique(result.AllowedEgress, describePolicyRule(r.To, r.Ports, p.Namespace)...)
+				}
+			}
+		}
+		result.IngressIsolated = result.IngressIsolated && ingressIsolated
+		result.EgressIsolated = result.EgressIsolated && egressIsolated
+
+		for _, p := range n.adminSelecting(pod) {
+			result.Policies = appendUnique(result.Policies, "AdminNetworkPolicy/"+p.Name)
+			for _, r := range p.Spec.Ingress {
+				result.AdminRules = appendUnique(result.AdminRules, describeAdminRule(p, r, "ingress from", r.From))
+			}
+			for _, r := range p.Spec.Egress {
+				result.AdminRules = appendUnique(result.AdminRules, describeAdminRule(p, r, "egress to", r.To))
+			}
+		}
+	}
+	sort.Strings(result.Policies)
+	sort.Strings(result.AllowedIngress)
+	sort.Strings(result.AllowedEgress)
+
+	return result
+}
+
+// policyTypes tells if a NetworkPolicy isolates ingress and egress. Without
+// policyTypes, egress is only isolated by policies with egress rules.
+func policyTypes(p networkingv1.NetworkPolicy) (bool, bool) {
+	if len(p.Spec.PolicyTypes) == 0 {
+		return true, len(p.Spec.Egress) > 0
+	}
+	return slices.Contains(p.Spec.PolicyTypes, networkingv1.PolicyTypeIngress),
+		slices.Contains(p.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		log.Debugf("Invalid label selector %v: %s", selector, err)
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
+
+// describePolicyRule describes the peers and ports allowed by a rule, one
+// entry per peer, e.g. "pods app=web in namespace shop on TCP/5432"
+func describePolicyRule(peers []networkingv1.NetworkPolicyPeer, ports []networkingv1.NetworkPolicyPort, namespace string) []string {
+	onPorts := ""
+	if len(ports) > 0 {
+		onPorts = " on " + describePolicyPorts(ports)
+	}
+	if len(peers) == 0 {
+		return []string{"any" + onPorts}
+	}
+
+	descriptions := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		descriptions = append(descriptions, describePolicyPeer(peer, namespace)+onPorts)
+	}
+	return descriptions
+}
+
+func describePolicyPeer(peer networkingv1.NetworkPolicyPeer, namespace string) string {
+	if peer.IPBlock != nil {
+		if len(peer.IPBlock.Except) > 0 {
+			return fmt.Sprintf("%s except %s", peer.IPBlock.CIDR, strings.Join(peer.IPBlock.Except, ", "))
+		}
+		return peer.IPBlock.CIDR
+	}
+
+	namespaces := "namespace " + namespace
+	if peer.NamespaceSelector != nil {
+		namespaces = "namespaces " + describeSelector(peer.NamespaceSelector)
+	}
+	if peer.PodSelector == nil {
+		return namespaces
+	}
+	return fmt.Sprintf("pods %s in %s", describeSelector(peer.PodSelector), namespaces)
+}
+
+func describePolicyPorts(ports []networkingv1.NetworkPolicyPort) string {
+	described := make([]string, 0, len(ports))
+	for _, p := range ports {
+		protocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			protocol = *p.Protocol
+		}
+		switch {
+		case p.Port == nil:
+			described = append(described, string(protocol))
+		case p.EndPort != nil:
+			described = append(described, fmt.Sprintf("%s/%s-%d", protocol, p.Port.String(), *p.EndPort))
+		default:
+			described = append(described, fmt.Sprintf("%s/%s", protocol, p.Port.String()))
+		}
+	}
+	return strings.Join(described, ", ")
+}
+
+// describeAdminRule describes an AdminNetworkPolicy rule, e.g.
+// "priority 10 Deny ingress from namespaces tenant=true (deny-tenants)"
+func describeAdminRule(p adminNetworkPolicy, r adminNetworkPolicyRule, direction string, peers []adminNetworkPolicyPeer) string {
+	described := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		switch {
+		case peer.Namespaces != nil:
+			described = append(described, "namespaces "+describeSelector(peer.Namespaces))
+		case peer.Pods != nil:
+			described = append(described, fmt.Sprintf("pods %s in namespaces %s", describeSelector(&peer.Pods.PodSelector), describeSelector(&peer.Pods.NamespaceSelector)))
+		case peer.Nodes != nil:
+			described = append(described, "nodes "+describeSelector(peer.Nodes))
+		case len(peer.Networks) > 0:
+			described = append(described, strings.Join(peer.Networks, ", "))
+		}
+	}
+
+	ports := make([]string, 0, len(r.Ports))
+	for _, port := range r.Ports {
+		switch {
+		case port.PortNumber != nil:
+			ports = append(ports, fmt.Sprintf("%s/%d", port.PortNumber.Protocol, port.PortNumber.Port))
+		case port.PortRange != nil:
+			ports = append(ports, fmt.Sprintf("%s/%d-%d", port.PortRange.Protocol, port.PortRange.Start, port.PortRange.End))
+		case port.NamedPort != nil:
+			ports = append(ports, *port.NamedPort)
+		}
+	}
+
+	description := fmt.Sprintf("priority %d %s %s %s", p.Spec.Priority, r.Action, direction, strings.Join(described, "; "))
+	if len(ports) > 0 {
+		description += " on " + strings.Join(ports, ", ")
+	}
+	if r.Name != "" {
+		description += fmt.Sprintf(" (%s)", r.Name)
+	}
+	return description
+}
+
+// describeSelector formats a label selector, "all" when it's empty
+func describeSelector(selector *metav1.LabelSelector) string {
+	if s := metav1.FormatLabelSelector(selector); s != "<none>" {
+		return s
+	}
+	return "all"
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,120 @@
+package portforward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Tunnel forwards local ports on 127.0.0.1 to the ports of a pod, until it's
+// closed or its context is done
+type Tunnel struct {
+	namespace string
+	pod       string
+	// local port of each remote port
+	ports map[int32]uint16
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// Start forwards the given remote ports of a pod to free local ports, and
+// returns once the tunnel is ready
+func Start(ctx context.Context, config *rest.Config, namespace string, pod string, remotePorts []int32) (*Tunnel, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+	url := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	ports := make([]string, 0, len(remotePorts))
+	for _, p := range remotePorts {
+		ports = append(ports, fmt.Sprintf("0:%d", p))
+	}
+
+	t := &Tunnel{
+		namespace: namespace,
+		pod:       pod,
+		ports:     make(map[int32]uint16),
+		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	readyChan := make(chan struct{})
+	// errors of single connections, the tunnel keeps running
+	errOut := log.StandardLogger().WriterLevel(log.DebugLevel)
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, ports, t.stopChan, readyChan, io.Discard, errOut)
+	if err != nil {
+		errOut.Close()
+		return nil, err
+	}
+
+	log.Debugf("Starting port forward to pod %s/%s ports %v", namespace, pod, remotePorts)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(t.done)
+		defer errOut.Close()
+		errChan <- forwarder.ForwardPorts()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.Close()
+		case <-t.done:
+		}
+	}()
+
+	select {
+	case <-readyChan:
+	case err := <-errChan:
+		t.Close()
+		return nil, fmt.Errorf("port forward to pod %s/%s failed: %w", namespace, pod, err)
+	case <-ctx.Done():
+		t.Close()
+		return nil, ctx.Err()
+	}
+
+	forwarded, err := forwarder.GetPorts()
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	for _, p := range forwarded {
+		t.ports[int32(p.Remote)] = p.Local
+	}
+	return t, nil
+}
+
+// LocalPort is the local port forwarded to a remote port of the pod
+func (t *Tunnel) LocalPort(remote int32) (uint16, bool) {
+	p, ok := t.ports[remote]
+	return p, ok
+}
+
+// Close stops forwarding and waits for the listeners to be closed, it can
+// be called more than once
+func (t *Tunnel) Close() {
+	t.stopOnce.Do(func() {
+		close(t.stopChan)
+		log.Debugf("Stopped port forward to pod %s/%s", t.namespace, t.pod)
+	})
+	<-t.done
+}
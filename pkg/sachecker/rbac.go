@@ -0,0 +1,275 @@
+package sachecker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+const clusterAdminRole = "cluster-admin"
+
+// RBAC resolves the permissions granted to service accounts by Roles,
+// ClusterRoles and their bindings
+type RBAC struct {
+	// roles by namespace/name
+	roles               map[string]rbacv1.Role
+	clusterRoles        map[string]rbacv1.ClusterRole
+	roleBindings        []rbacv1.RoleBinding
+	clusterRoleBindings []rbacv1.ClusterRoleBinding
+}
+
+// Permissions are the effective permissions of a service account, with flags
+// for the rules worth reviewing
+type Permissions struct {
+	ServiceAccount string           `yaml:"serviceAccount"`
+	Rules          []PermissionRule `yaml:"rules"`
+	// Wildcard is set when a rule uses * in its verbs, API groups or
+	// resources
+	Wildcard bool `yaml:"wildcard"`
+	// SecretsAccess is set when secrets can be read
+	SecretsAccess bool `yaml:"secretsAccess"`
+	// PodExec is set when commands can be run in, or attached to, pods
+	PodExec     bool `yaml:"podExec"`
+	Escalate    bool `yaml:"escalate"`
+	Bind        bool `yaml:"bind"`
+	Impersonate bool `yaml:"impersonate"`
+	// ClusterAdmin is set when everything is allowed cluster wide
+	ClusterAdmin bool `yaml:"clusterAdmin"`
+}
+
+// PermissionRule is a PolicyRule granted to a service account, and where it
+// comes from
+type PermissionRule struct {
+	// Namespace is empty for rules granted cluster wide
+	Namespace       string   `yaml:"namespace,omitempty"`
+	Verbs           []string `yaml:"verbs"`
+	APIGroups       []string `yaml:"apiGroups,omitempty"`
+	Resources       []string `yaml:"resources,omitempty"`
+	ResourceNames   []string `yaml:"resourceNames,omitempty"`
+	NonResourceURLs []string `yaml:"nonResourceURLs,omitempty"`
+	// Role is Role/<name> or ClusterRole/<name>
+	Role string `yaml:"role"`
+	// Binding is RoleBinding/<namespace>/<name> or ClusterRoleBinding/<name>
+	Binding string `yaml:"binding"`
+	// Subject of the binding matching the service account, e.g.
+	// Group/system:serviceaccounts:<namespace>
+	Subject string `yaml:"subject"`
+}
+
+func NewRBAC(roles []rbacv1.Role, clusterRoles []rbacv1.ClusterRole, roleBindings []rbacv1.RoleBinding, clusterRoleBindings []rbacv1.ClusterRoleBinding) *RBAC {
+	r := &RBAC{
+		roles:               make(map[string]rbacv1.Role),
+		clusterRoles:        make(map[string]rbacv1.ClusterRole),
+		roleBindings:        roleBindings,
+		clusterRoleBindings: clusterRoleBindings,
+	}
+	for _, role := range roles {
+		r.roles[role.Namespace+"/"+role.Name] = role
+	}
+	for _, role := range clusterRoles {
+		r.clusterRoles[role.Name] = role
+	}
+	return r
+}
+
+// Permissions lists the rules granted to a service account, directly or
+// through the groups it belongs to
+func (r *RBAC) Permissions(namespace string, serviceAccount string) *Permissions {
+	p := &Permissions{
+		ServiceAccount: namespace + "/" + serviceAccount,
+		Rules:          []PermissionRule{},
+	}
+
+	for _, b := range r.clusterRoleBindings {
+		subject, ok := matchingSubject(b.Subjects, "", namespace, serviceAccount)
+		if !ok {
+			continue
+		}
+		role, rules, found := r.roleRules(b.RoleRef, "")
+		if !found {
+			continue
+		}
+		if b.RoleRef.Kind == "ClusterRole" && b.RoleRef.Name == clusterAdminRole {
+			p.ClusterAdmin = true
+		}
+		p.addRules("", rules, role, "ClusterRoleBinding/"+b.Name, subject)
+	}
+
+	for _, b := range r.roleBindings {
+		subject, ok := matchingSubject(b.Subjects, b.Namespace, namespace, serviceAccount)
+		if !ok {
+			continue
+		}
+		role, rules, found := r.roleRules(b.RoleRef, b.Namespace)
+		if !found {
+			continue
+		}
+		p.addRules(b.Namespace, rules, role, fmt.Sprintf("RoleBinding/%s/%s", b.Namespace, b.Name), subject)
+	}
+
+	sort.SliceStable(p.Rules, func(i, j int) bool {
+		return p.Rules[i].Namespace < p.Rules[j].Namespace
+	})
+	return p
+}
+
+// roleRules are the rules of the role a binding refers to, not found when
+// the role doesn't exist
+func (r *RBAC) roleRules(ref rbacv1.RoleRef, namespace string) (string, []rbacv1.PolicyRule, bool) {
+	switch ref.Kind {
+	case "ClusterRole":
+		role, ok := r.clusterRoles[ref.Name]
+		return "ClusterRole/" + ref.Name, role.Rules, ok
+	case "Role":
+		role, ok := r.roles[namespace+"/"+ref.Name]
+		return "Role/" + ref.Name, role.Rules, ok
+	}
+	return "", nil, false
+}
+
+// matchingSubject finds the subject of a binding that applies to the service
+// account: itself, its user name, or the groups of service accounts and
+// authenticated users
+func matchingSubject(subjects []rbacv1.Subject, bindingNamespace string, namespace string, serviceAccount string) (string, bool) {
+	groups := []string{
+		"system:serviceaccounts",
+		"system:serviceaccounts:" + namespace,
+		"system:authenticated",
+	}
+	for _, s := range subjects {
+		switch s.Kind {
+		case rbacv1.ServiceAccountKind:
+			ns := s.Namespace
+			if ns == "" {
+				ns = bindingNamespace
+			}
+			if ns == namespace && s.Name == serviceAccount {
+				return fmt.Sprintf("ServiceAccount/%s/%s", ns, s.Name), true
+			}
+		case rbacv1.UserKind:
+			if s.Name == fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount) {
+				return "User/" + s.Name, true
+			}
+		case rbacv1.GroupKind:
+			if slices.Contains(groups, s.Name) {
+				return "Group/" + s.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *Permissions) addRules(namespace string, rules []rbacv1.PolicyRule, role, binding, subject string) {
+	for _, rule := range rules {
+		r := PermissionRule{
+			Namespace:       namespace,
+			Verbs:           rule.Verbs,
+			APIGroups:       rule.APIGroups,
+			Resources:       rule.Resources,
+			ResourceNames:   rule.ResourceNames,
+			NonResourceURLs: rule.NonResourceURLs,
+			Role:            role,
+			Binding:         binding,
+			Subject:         subject,
+		}
+		p.Rules = append(p.Rules, r)
+
+		if r.HasWildcard() {
+			p.Wildcard = true
+		}
+		if ruleAllows(rule, "", "secrets", "get", "list", "watch") {
+			p.SecretsAccess = true
+		}
+		if ruleAllows(rule, "", "pods/exec", "create", "get") || ruleAllows(rule, "", "pods/attach", "create", "get") {
+			p.PodExec = true
+		}
+		if ruleAllows(rule, rbacv1.GroupName, "roles", "escalate") || ruleAllows(rule, rbacv1.GroupName, "clusterroles", "escalate") {
+			p.Escalate = true
+		}
+		if ruleAllows(rule, rbacv1.GroupName, "roles", "bind") || ruleAllows(rule, rbacv1.GroupName, "clusterroles", "bind") {
+			p.Bind = true
+		}
+		for _, impersonated := range []string{"users", "groups", "serviceaccounts"} {
+			if ruleAllows(rule, "", impersonated, "impersonate") {
+				p.Impersonate = true
+			}
+		}
+		if namespace == "" && ruleAllows(rule, "*", "*", "*") {
+			p.ClusterAdmin = true
+		}
+	}
+}
+
+// HasWildcard tells if the rule uses * in its verbs, API groups or resources
+func (r PermissionRule) HasWildcard() bool {
+	return slices.Contains(r.Verbs, rbacv1.VerbAll) || slices.Contains(r.APIGroups, rbacv1.APIGroupAll) || slices.Contains(r.Resources, rbacv1.ResourceAll)
+}
+
+// Allows tells if the service account may use a verb on a named resource of
+// an API group in a namespace, through cluster wide or namespaced rules
+func (p *Permissions) Allows(namespace string, apiGroup string, resource string, name string, verb string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Rules {
+		if r.Namespace != "" && r.Namespace != namespace {
+			continue
+		}
+		if len(r.ResourceNames) > 0 && !slices.Contains(r.ResourceNames, name) {
+			continue
+		}
+		rule := rbacv1.PolicyRule{Verbs: r.Verbs, APIGroups: r.APIGroups, Resources: r.Resources}
+		if ruleAllows(rule, apiGroup, resource, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleAllows tells if a rule grants any of the verbs on a resource of an API
+// group, regardless of resource names. Resources can be <resource>/<subresource>.
+func ruleAllows(rule rbacv1.PolicyRule, apiGroup string, resource string, verbs ...string) bool {
+	if !slices.Contains(rule.APIGroups, rbacv1.APIGroupAll) && !slices.Contains(rule.APIGroups, apiGroup) {
+		return false
+	}
+	if !slices.ContainsFunc(verbs, func(v string) bool {
+		return slices.Contains(rule.Verbs, rbacv1.VerbAll) || slices.Contains(rule.Verbs, v)
+	}) {
+		return false
+	}
+	main, _, isSubresource := strings.Cut(resource, "/")
+	for _, r := range rule.Resources {
+		if r == rbacv1.ResourceAll || r == resource || (isSubresource && r == main+"/*") {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags lists the flags set, for reports
+func (p *Permissions) Flags() []string {
+	if p == nil {
+		return []string{}
+	}
+	flags := []string{}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"wildcard", p.Wildcard},
+		{"secrets", p.SecretsAccess},
+		{"pods/exec", p.PodExec},
+		{"escalate", p.Escalate},
+		{"bind", p.Bind},
+		{"impersonate", p.Impersonate},
+		{"cluster-admin", p.ClusterAdmin},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
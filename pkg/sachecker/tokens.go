@@ -0,0 +1,149 @@
+package sachecker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Tokens is how the service account token of a component's pods is exposed
+type Tokens struct {
+	ServiceAccount string `yaml:"serviceAccount"`
+	// Automount is set when the API server token is mounted in any of the
+	// pods, AutomountSource tells which setting decided: pod,
+	// serviceaccount, default, unknown when the service account wasn't
+	// collected, or mixed when the pods differ
+	Automount       bool   `yaml:"automount"`
+	AutomountSource string `yaml:"automountSource"`
+	// ProjectedTokens are bound tokens, including the automounted one
+	ProjectedTokens []ProjectedToken `yaml:"projectedTokens,omitempty"`
+	// LegacySecrets are the kubernetes.io/service-account-token secrets of
+	// the service account, their token doesn't expire
+	LegacySecrets []string `yaml:"legacySecrets,omitempty"`
+	// MountedLegacySecrets are legacy token secrets mounted in the pods
+	MountedLegacySecrets []string `yaml:"mountedLegacySecrets,omitempty"`
+	// SecretsListed is false when the secrets couldn't be listed, so legacy
+	// tokens are unknown
+	SecretsListed bool `yaml:"secretsListed"`
+}
+
+// ProjectedToken is a serviceAccountToken source of a projected volume
+type ProjectedToken struct {
+	Volume            string `yaml:"volume"`
+	Path              string `yaml:"path"`
+	Audience          string `yaml:"audience,omitempty"`
+	ExpirationSeconds int64  `yaml:"expirationSeconds"`
+}
+
+const (
+	automountPod            = "pod"
+	automountServiceAccount = "serviceaccount"
+	automountDefault        = "default"
+	automountUnknown        = "unknown"
+	automountMixed          = "mixed"
+
+	// defaultTokenExpirationSeconds applies to projected tokens without
+	// expirationSeconds
+	defaultTokenExpirationSeconds = 3600
+)
+
+// TokensFor analyses the tokens of the pods of a component. sa is nil when
+// the service accounts couldn't be listed, secrets is nil when the secrets
+// couldn't be, they're the service account token secrets of the namespace.
+func TokensFor(pods []corev1.Pod, serviceAccount string, sa *corev1.ServiceAccount, secrets []corev1.Secret) *Tokens {
+	t := &Tokens{
+		ServiceAccount: pods[0].Namespace + "/" + serviceAccount,
+		SecretsListed:  secrets != nil,
+	}
+
+	for i, p := range pods {
+		automount, source := podAutomount(p, sa)
+		t.Automount = t.Automount || automount
+		if i == 0 {
+			t.AutomountSource = source
+		} else if source != t.AutomountSource {
+			t.AutomountSource = automountMixed
+		}
+	}
+
+	for _, s := range secrets {
+		if s.Type == corev1.SecretTypeServiceAccountToken && s.Annotations[corev1.ServiceAccountNameKey] == serviceAccount {
+			t.LegacySecrets = append(t.LegacySecrets, s.Name)
+		}
+	}
+	sort.Strings(t.LegacySecrets)
+
+	for _, p := range pods {
+		for _, v := range p.Spec.Volumes {
+			if v.Secret != nil && slices.Contains(t.LegacySecrets, v.Secret.SecretName) && !slices.Contains(t.MountedLegacySecrets, v.Secret.SecretName) {
+				t.MountedLegacySecrets = append(t.MountedLegacySecrets, v.Secret.SecretName)
+			}
+			if v.Projected == nil {
+				continue
+			}
+			for _, source := range v.Projected.Sources {
+				if source.ServiceAccountToken == nil {
+					continue
+				}
+				token := ProjectedToken{
+					Volume:            v.Name,
+					Path:              source.ServiceAccountToken.Path,
+					Audience:          source.ServiceAccountToken.Audience,
+					ExpirationSeconds: defaultTokenExpirationSeconds,
+				}
+				if source.ServiceAccountToken.ExpirationSeconds != nil {
+					token.ExpirationSeconds = *source.ServiceAccountToken.ExpirationSeconds
+				}
+				// the automounted volume has a random suffix per pod
+				if !slices.ContainsFunc(t.ProjectedTokens, func(p ProjectedToken) bool {
+					return p.Path == token.Path && p.Audience == token.Audience && p.ExpirationSeconds == token.ExpirationSeconds
+				}) {
+					t.ProjectedTokens = append(t.ProjectedTokens, token)
+				}
+			}
+		}
+	}
+	sort.Strings(t.MountedLegacySecrets)
+
+	return t
+}
+
+// podAutomount tells if the token is automounted in a pod, and which
+// setting decided. Without the service account, the token is assumed
+// mounted as by default.
+func podAutomount(p corev1.Pod, sa *corev1.ServiceAccount) (bool, string) {
+	switch {
+	case p.Spec.AutomountServiceAccountToken != nil:
+		return *p.Spec.AutomountServiceAccountToken, automountPod
+	case sa == nil:
+		return true, automountUnknown
+	case sa.AutomountServiceAccountToken != nil:
+		return *sa.AutomountServiceAccountToken, automountServiceAccount
+	}
+	return true, automountDefault
+}
+
+// String summarizes the tokens for reports, e.g.
+// "automount projected:3607s legacy:1 mounted-legacy:1"
+func (t *Tokens) String() string {
+	if t == nil {
+		return ""
+	}
+	parts := []string{}
+	if t.Automount {
+		parts = append(parts, "automount")
+	}
+	for _, p := range t.ProjectedTokens {
+		parts = append(parts, fmt.Sprintf("projected:%ds", p.ExpirationSeconds))
+	}
+	if len(t.LegacySecrets) > 0 {
+		parts = append(parts, fmt.Sprintf("legacy:%d", len(t.LegacySecrets)))
+	}
+	if len(t.MountedLegacySecrets) > 0 {
+		parts = append(parts, fmt.Sprintf("mounted-legacy:%d", len(t.MountedLegacySecrets)))
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,40 +1,60 @@
 package sslchecker
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"os/signal"
-	"time"
 
-	w "github.com/sfowl/pod-checker/pkg/cmdwrapper"
 	h "github.com/sfowl/pod-checker/pkg/helpers"
-	n "github.com/sfowl/pod-checker/pkg/netutils"
+	pf "github.com/sfowl/pod-checker/pkg/portforward"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
 )
 
-const containerReportsDir string = "/reports"
+// ServiceReport is the TLS configuration of each port of a service, probed
+// through one of its pods
+type ServiceReport struct {
+	Service   string       `json:"service"`
+	Namespace string       `json:"namespace"`
+	Pod       string       `json:"pod"`
+	Ports     []PortReport `json:"ports"`
+}
+
+type PortReport struct {
+	Name       string          `json:"name,omitempty"`
+	Port       int32           `json:"port"`
+	TargetPort int32           `json:"targetPort"`
+	Protocol   corev1.Protocol `json:"protocol"`
+	// Result is empty when the port couldn't be forwarded, Error tells why
+	Result *ProbeResult `json:"result,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
 
 type SslChecker struct {
-	namespace     string
-	serviceName   string
-	port          int32
+	config        *rest.Config
+	pod           corev1.Pod
+	service       corev1.Service
 	hostReportDir string
-	cmds          []w.CmdWrapper
 }
 
-func NewSslChecker(namespace string, serviceName string, port int32, hostReportDir string) SslChecker {
-	c := SslChecker{}
-	c.namespace = namespace
-	c.serviceName = serviceName
-	c.port = port
+func NewSslChecker(config *rest.Config, pod corev1.Pod, service corev1.Service, hostReportDir string) *SslChecker {
+	c := &SslChecker{}
+	c.config = config
+	c.pod = pod
+	c.service = service
 	c.hostReportDir = hostReportDir
 
 	return c
 }
 
-func SslCheckerForServices(namespace string, serviceName string, ports []corev1.ServicePort, group string) {
+// SslCheckerForServices probes the services of a pod, it only fails when ctx
+// is done
+func SslCheckerForServices(ctx context.Context, config *rest.Config, pod corev1.Pod, services []corev1.Service, group string) error {
 	path, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
@@ -47,124 +67,113 @@ func SslCheckerForServices(namespace string, serviceName string, ports []corev1.
 	}
 
 	log.Infof("Ssl checker reports directory: %s", reportDir)
-	for _, p := range ports {
-		s := NewSslChecker(namespace, serviceName, p.Port, reportDir)
-		log.Infof("Ssl checker starting for %s:%d", s.fqdnSvc(), p.Port)
-		s.Run()
+	for _, s := range services {
+		c := NewSslChecker(config, pod, s, reportDir)
+		log.Infof("Ssl checker starting for %s", c.fqdnSvc())
+		if err := c.Run(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Errorf("Ssl checker failed for %s: %s", c.fqdnSvc(), err)
+		}
 	}
-
+	return nil
 }
 
 func (c *SslChecker) fqdnSvc() string {
-	return fmt.Sprintf("%s.%s.svc", c.serviceName, c.namespace)
+	return fmt.Sprintf("%s.%s.svc", c.service.Name, c.service.Namespace)
 }
 
-func (c *SslChecker) Run() {
-
-	localPort, err := n.GetFreePort()
-	c.cleanUpOnSignal()
-
-	if err != nil {
-		panic(err)
-	}
-
-	// Support accessing to local host interfaces from a rootless container
-	// This address will be used as a gateway between the host network and the sslchecker container's network
-	gateway := n.GetDefaultOutboundIP().String()
-	svc := fmt.Sprintf("%s:%d", c.fqdnSvc(), c.port)
-
+// Run forwards the TCP ports of the service from its pod, probes them and
+// writes the report
+func (c *SslChecker) Run(ctx context.Context) error {
 	hostReportFile := c.reportFile(c.hostReportDir)
-
 	if h.CheckFileExist(hostReportFile, fmt.Sprintf("Report file %s exists, it will not be overwritten. If you want to regenerate it, delete the old report", hostReportFile)) {
-		return
-	}
-
-	log.Infof("Starting port forward for service %s in %s:%d", svc, gateway, localPort)
-
-	portForwardArgs := []string{
-		"port-forward",
-		"--address", gateway,
-		"-n", c.namespace,
-		fmt.Sprintf("svc/%s", c.serviceName),
-		fmt.Sprintf("%d:%d", localPort, c.port),
-	}
-
-	portForward := w.NewCmdWrapper("oc", portForwardArgs)
-
-	if err := portForward.Start(); err != nil {
-		panic(err)
-	}
-
-	log.Infof("Port forward for service %s started successfully", svc)
-
-	c.cmds = append(c.cmds, portForward)
-	// Wait for port-forward to start the server
-	<-time.After(2 * time.Second)
-
-	testSslArgs := []string{
-		"run", "--rm", "-t",
-		"--add-host", fmt.Sprintf("%s:%s", c.fqdnSvc(), gateway),
-		"-v", fmt.Sprintf("%s:%s:Z", c.hostReportDir, containerReportsDir),
-		"--network", "slirp4netns:allow_host_loopback=true",
-		//@FIXME: quick solution to fix permissions issues with volumes mounted on rootless containers
-		// ref: https://www.redhat.com/sysadmin/debug-rootless-podman-mounted-volumes
-		"--user", "root",
-		"drwetter/testssl.sh",
-		"--jsonfile", c.reportFile(containerReportsDir),
-		fmt.Sprintf("%s:%d", c.fqdnSvc(), localPort),
-	}
-
-	log.Infof("Starting sslchecker for service %s", svc)
-
-	testssl := w.NewCmdWrapper("podman", testSslArgs)
-
-	if err := testssl.Start(); err != nil {
-		c.panic(err)
+		return nil
 	}
 
-	c.cmds = append(c.cmds, testssl)
-
-	if err := testssl.StdOut(); err != nil {
-		c.panic(err)
+	report := ServiceReport{
+		Service:   c.service.Name,
+		Namespace: c.service.Namespace,
+		Pod:       c.pod.Name,
+		Ports:     []PortReport{},
 	}
-
-	if err := testssl.StdErr(); err != nil {
-		c.panic(err)
+	forwarded := []int32{}
+	for _, p := range c.service.Spec.Ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		port := PortReport{
+			Name:       p.Name,
+			Port:       p.Port,
+			TargetPort: targetPort(c.pod, p),
+			Protocol:   protocol,
+		}
+		if protocol != corev1.ProtocolTCP {
+			port.Error = "not a TCP port"
+		} else if port.TargetPort == 0 {
+			port.Error = fmt.Sprintf("target port %s not found on pod %s", p.TargetPort.String(), c.pod.Name)
+		} else if !slices.Contains(forwarded, port.TargetPort) {
+			forwarded = append(forwarded, port.TargetPort)
+		}
+		report.Ports = append(report.Ports, port)
 	}
 
-	if err := testssl.Wait(); err != nil {
-		c.panic(err)
+	if len(forwarded) > 0 {
+		tunnel, err := pf.Start(ctx, c.config, c.pod.Namespace, c.pod.Name, forwarded)
+		if err != nil {
+			return err
+		}
+		defer tunnel.Close()
+
+		for i, p := range report.Ports {
+			local, ok := tunnel.LocalPort(p.TargetPort)
+			if p.Error != "" || !ok {
+				continue
+			}
+			log.Infof("Probing TLS on %s:%d", c.fqdnSvc(), p.Port)
+			result := Probe(ctx, fmt.Sprintf("127.0.0.1:%d", local), c.fqdnSvc())
+			report.Ports[i].Result = &result
+		}
+		if ctx.Err() != nil {
+			// partial results, don't keep them as a report
+			return ctx.Err()
+		}
 	}
 
-	log.Infof("Finished sslchecker for service %s", svc)
-
-	if err := portForward.Kill(); err != nil {
-		panic(err)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
 	}
-}
-
-func (c *SslChecker) cleanUp() {
-	for _, cmd := range c.cmds {
-		cmd.Kill()
+	if err := os.WriteFile(hostReportFile, data, 0644); err != nil {
+		return err
 	}
-}
 
-func (c *SslChecker) panic(err any) {
-	c.cleanUp()
-	panic(err)
+	log.Infof("Finished sslchecker for service %s", c.fqdnSvc())
+	return nil
 }
 
-func (c *SslChecker) cleanUpOnSignal() {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	go func() {
-		for range sigChan {
-			c.cleanUp()
-			os.Exit(1)
+// targetPort resolves the container port of a service port, 0 when a named
+// port isn't declared by the pod
+func targetPort(pod corev1.Pod, p corev1.ServicePort) int32 {
+	switch {
+	case p.TargetPort.Type == intstr.String:
+		for _, container := range pod.Spec.Containers {
+			for _, cp := range container.Ports {
+				if cp.Name == p.TargetPort.StrVal {
+					return cp.ContainerPort
+				}
+			}
 		}
-	}()
+		return 0
+	case p.TargetPort.IntVal != 0:
+		return p.TargetPort.IntVal
+	default:
+		return p.Port
+	}
 }
 
 func (c *SslChecker) reportFile(dir string) string {
-	return fmt.Sprintf("%s/%s_%d.json", dir, c.fqdnSvc(), c.port)
+	return fmt.Sprintf("%s/%s.json", dir, c.fqdnSvc())
 }
@@ -0,0 +1,218 @@
+package sslchecker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const probeTimeout = 5 * time.Second
+
+// tlsVersions are the protocol versions probed, newest first. SSLv3 and
+// older are not supported by crypto/tls.
+var tlsVersions = []uint16{tls.VersionTLS13, tls.VersionTLS12, tls.VersionTLS11, tls.VersionTLS10}
+
+// ProbeResult is what a TLS endpoint supports, and the certificate chain it
+// serves
+type ProbeResult struct {
+	Address    string `json:"address"`
+	ServerName string `json:"serverName,omitempty"`
+	// TLS is false when no handshake succeeded, Error tells why
+	TLS          bool              `json:"tls"`
+	Error        string            `json:"error,omitempty"`
+	Protocols    []ProtocolResult  `json:"protocols"`
+	Certificates []CertificateInfo `json:"certificates,omitempty"`
+	SelfSigned   bool              `json:"selfSigned"`
+	Expired      bool              `json:"expired"`
+	// VerifyError is set when the chain isn't trusted by the system roots
+	// for ServerName, expected for certificates of the cluster's service CA
+	VerifyError string `json:"verifyError,omitempty"`
+}
+
+type ProtocolResult struct {
+	Version   string `json:"version"`
+	Supported bool   `json:"supported"`
+	// CipherSuites accepted with this version. TLS 1.3 suites can't be
+	// chosen by crypto/tls, only the negotiated one is listed.
+	CipherSuites []CipherSuiteResult `json:"cipherSuites,omitempty"`
+}
+
+type CipherSuiteResult struct {
+	Name string `json:"name"`
+	// Insecure suites have known security issues, e.g. RC4, 3DES, CBC with
+	// SHA-256 or RSA key exchange without forward secrecy
+	Insecure bool `json:"insecure"`
+}
+
+type CertificateInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SANs               []string  `json:"sans,omitempty"`
+	SerialNumber       string    `json:"serialNumber"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	PublicKeyAlgorithm string    `json:"publicKeyAlgorithm"`
+	IsCA               bool      `json:"isCA"`
+}
+
+// Probe connects to a TLS endpoint once per protocol version and cipher
+// suite, and reports the ones it accepts. serverName is sent as SNI and
+// used to verify the certificate, it can be empty.
+func Probe(ctx context.Context, address string, serverName string) ProbeResult {
+	result := ProbeResult{
+		Address:    address,
+		ServerName: serverName,
+		Protocols:  []ProtocolResult{},
+	}
+
+	var lastErr error
+	for _, version := range tlsVersions {
+		protocol := ProtocolResult{Version: tls.VersionName(version)}
+		if version == tls.VersionTLS13 {
+			state, err := handshake(ctx, address, serverName, version, nil)
+			if err != nil {
+				lastErr = err
+			} else {
+				protocol.CipherSuites = append(protocol.CipherSuites, cipherSuiteResult(state.CipherSuite))
+				result.setCertificates(state, serverName)
+			}
+		} else {
+			for _, suite := range versionCipherSuites(version) {
+				state, err := handshake(ctx, address, serverName, version, []uint16{suite})
+				if err != nil {
+					lastErr = err
+					if ctx.Err() != nil || isDialError(err) {
+						break
+					}
+					continue
+				}
+				protocol.CipherSuites = append(protocol.CipherSuites, cipherSuiteResult(state.CipherSuite))
+				result.setCertificates(state, serverName)
+			}
+		}
+		protocol.Supported = len(protocol.CipherSuites) > 0
+		result.TLS = result.TLS || protocol.Supported
+		result.Protocols = append(result.Protocols, protocol)
+		if ctx.Err() != nil || (!result.TLS && isDialError(lastErr)) {
+			// nothing listening, no need to try the other versions
+			break
+		}
+	}
+
+	if !result.TLS && lastErr != nil {
+		result.Error = lastErr.Error()
+	}
+	return result
+}
+
+// handshake connects with a single protocol version, and the given cipher
+// suites for versions older than TLS 1.3
+func handshake(ctx context.Context, address, serverName string, version uint16, suites []uint16) (tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	dialer := tls.Dialer{
+		Config: &tls.Config{
+			ServerName: serverName,
+			MinVersion: version,
+			MaxVersion: version,
+			// the chain is verified separately, to report on untrusted
+			// certificates rather than fail
+			InsecureSkipVerify: true,
+			CipherSuites:       suites,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.(*tls.Conn).ConnectionState(), nil
+}
+
+// isDialError tells if the TCP connection failed, before any TLS handshake
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// versionCipherSuites lists the secure and insecure suites crypto/tls
+// implements for a protocol version
+func versionCipherSuites(version uint16) []uint16 {
+	suites := []uint16{}
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if slices.Contains(s.SupportedVersions, version) {
+			suites = append(suites, s.ID)
+		}
+	}
+	return suites
+}
+
+func cipherSuiteResult(id uint16) CipherSuiteResult {
+	insecure := slices.ContainsFunc(tls.InsecureCipherSuites(), func(s *tls.CipherSuite) bool { return s.ID == id })
+	return CipherSuiteResult{Name: tls.CipherSuiteName(id), Insecure: insecure}
+}
+
+// setCertificates records the chain served in the first successful
+// handshake
+func (r *ProbeResult) setCertificates(state tls.ConnectionState, serverName string) {
+	if len(r.Certificates) > 0 || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, c := range state.PeerCertificates {
+		r.Certificates = append(r.Certificates, newCertificateInfo(c))
+		if now.After(c.NotAfter) || now.Before(c.NotBefore) {
+			r.Expired = true
+		}
+	}
+
+	leaf := state.PeerCertificates[0]
+	r.SelfSigned = isSelfSigned(leaf)
+
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates}); err != nil {
+		r.VerifyError = err.Error()
+	}
+}
+
+func newCertificateInfo(c *x509.Certificate) CertificateInfo {
+	sans := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+
+	return CertificateInfo{
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		SANs:               sans,
+		SerialNumber:       fmt.Sprintf("%X", c.SerialNumber),
+		NotBefore:          c.NotBefore,
+		NotAfter:           c.NotAfter,
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: c.PublicKeyAlgorithm.String(),
+		IsCA:               c.IsCA,
+	}
+}
+
+// isSelfSigned tells if a certificate is signed by its own key
+func isSelfSigned(c *x509.Certificate) bool {
+	// CheckSignatureFrom would reject leaf certificates, which aren't CAs
+	return c.Subject.String() == c.Issuer.String() &&
+		c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil
+}
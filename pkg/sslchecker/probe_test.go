@@ -0,0 +1,202 @@
+package sslchecker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// newCertificate creates a certificate for the SANs, signed by parent, or
+// self-signed when parent is nil
+func newCertificate(t *testing.T, cn string, sans []string, notAfter time.Time, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+
+	issuer, signer := template, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	if parent != nil {
+		cert.Certificate = append(cert.Certificate, parent.Certificate...)
+	}
+	return cert
+}
+
+// startTLSServer serves HTTPS with config, closed at the end of the test
+func startTLSServer(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = config
+	// failed handshakes are expected, the probe tries every version
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+func protocolResult(t *testing.T, result ProbeResult, version uint16) ProtocolResult {
+	t.Helper()
+
+	i := slices.IndexFunc(result.Protocols, func(p ProtocolResult) bool { return p.Version == tls.VersionName(version) })
+	if i < 0 {
+		t.Fatalf("%s not probed: %+v", tls.VersionName(version), result.Protocols)
+	}
+	return result.Protocols[i]
+}
+
+func TestProbeRestrictedTLS12(t *testing.T) {
+	cert := newCertificate(t, "web.example.test", []string{"web.example.test", "127.0.0.1"}, time.Now().Add(24*time.Hour), false, nil)
+	address := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+		},
+	})
+
+	result := Probe(context.Background(), address, "web.example.test")
+
+	if !result.TLS || result.Error != "" {
+		t.Fatalf("expected a TLS endpoint, got error %q", result.Error)
+	}
+	for _, version := range []uint16{tls.VersionTLS13, tls.VersionTLS11, tls.VersionTLS10} {
+		if p := protocolResult(t, result, version); p.Supported {
+			t.Errorf("%s should be rejected, got %+v", p.Version, p.CipherSuites)
+		}
+	}
+	tls12 := protocolResult(t, result, tls.VersionTLS12)
+	if !tls12.Supported {
+		t.Fatal("TLS 1.2 should be supported")
+	}
+	expected := []CipherSuiteResult{
+		{Name: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256", Insecure: true},
+		{Name: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", Insecure: false},
+	}
+	suites := append([]CipherSuiteResult{}, tls12.CipherSuites...)
+	slices.SortFunc(suites, func(a, b CipherSuiteResult) bool { return a.Name < b.Name })
+	if !slices.Equal(suites, expected) {
+		t.Errorf("expected cipher suites %+v, got %+v", expected, suites)
+	}
+
+	if len(result.Certificates) != 1 {
+		t.Fatalf("expected 1 certificate, got %d", len(result.Certificates))
+	}
+	if sans := result.Certificates[0].SANs; !slices.Equal(sans, []string{"web.example.test", "127.0.0.1"}) {
+		t.Errorf("unexpected SANs %v", sans)
+	}
+	if notAfter := result.Certificates[0].NotAfter; !notAfter.Equal(cert.Leaf.NotAfter) {
+		t.Errorf("expected the certificate to expire at %s, got %s", cert.Leaf.NotAfter, notAfter)
+	}
+	if !result.SelfSigned {
+		t.Error("the certificate should be self-signed")
+	}
+	if result.Expired {
+		t.Error("the certificate shouldn't be expired")
+	}
+	if result.VerifyError == "" {
+		t.Error("a self-signed certificate shouldn't be trusted")
+	}
+}
+
+func TestProbeExpiredChainTLS13(t *testing.T) {
+	ca := newCertificate(t, "test CA", nil, time.Now().Add(24*time.Hour), true, nil)
+	cert := newCertificate(t, "db.example.test", []string{"db.example.test"}, time.Now().Add(-time.Hour), false, &ca)
+	address := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	})
+
+	result := Probe(context.Background(), address, "db.example.test")
+
+	tls13 := protocolResult(t, result, tls.VersionTLS13)
+	if !tls13.Supported || len(tls13.CipherSuites) != 1 {
+		t.Fatalf("expected TLS 1.3 with the negotiated suite, got %+v", tls13)
+	}
+	if tls13.CipherSuites[0].Insecure {
+		t.Errorf("%s isn't insecure", tls13.CipherSuites[0].Name)
+	}
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS11, tls.VersionTLS10} {
+		if p := protocolResult(t, result, version); p.Supported {
+			t.Errorf("%s should be rejected", p.Version)
+		}
+	}
+
+	if len(result.Certificates) != 2 {
+		t.Fatalf("expected the leaf and the CA, got %d certificates", len(result.Certificates))
+	}
+	if leaf := result.Certificates[0]; leaf.Issuer != "CN=test CA" || leaf.IsCA {
+		t.Errorf("unexpected leaf %+v", leaf)
+	}
+	if result.SelfSigned {
+		t.Error("the leaf is signed by the CA, not self-signed")
+	}
+	if !result.Expired {
+		t.Error("the leaf should be expired")
+	}
+}
+
+func TestProbeNotListening(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	result := Probe(context.Background(), address, "")
+
+	if result.TLS || result.Error == "" {
+		t.Errorf("expected an error without TLS, got %+v", result)
+	}
+	if len(result.Protocols) != 1 {
+		t.Errorf("expected probing to stop after the first version, got %+v", result.Protocols)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/discovery"
+)
+
+// Platform is the flavour of the analysed cluster. It decides how exposure,
+// pod security and namespaces are interpreted.
+type Platform string
+
+const (
+	PlatformOpenShift  Platform = "openshift"
+	PlatformKubernetes Platform = "kubernetes"
+)
+
+const (
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	gatewayAPIGroup         = "gateway.networking.k8s.io"
+	routeAPIGroup           = "route.openshift.io"
+	securityAPIGroup        = "security.openshift.io"
+	// AdminNetworkPolicies are served when the network plugin supports them
+	adminNetworkPolicyAPIGroup = "policy.networking.k8s.io"
+)
+
+// platform of the cluster being analysed, set once the cluster data is read
+var platform = PlatformOpenShift
+
+func parsePlatform(s string) (Platform, error) {
+	switch p := Platform(strings.ToLower(s)); p {
+	case PlatformOpenShift, PlatformKubernetes:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown platform %q, expected %s or %s", s, PlatformOpenShift, PlatformKubernetes)
+}
+
+// servedGroups maps the API groups served by the cluster to their preferred
+// version
+func servedGroups(client discovery.DiscoveryInterface) (map[string]string, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	served := make(map[string]string)
+	for _, g := range groups.Groups {
+		served[g.Name] = g.PreferredVersion.Version
+	}
+	return served, nil
+}
+
+func detectPlatform(served map[string]string) Platform {
+	if served[routeAPIGroup] != "" {
+		return PlatformOpenShift
+	}
+	return PlatformKubernetes
+}
+
+// inferPlatform guesses the platform of offline cluster data, where the API
+// groups can't be discovered
+func inferPlatform(clusterData ClusterData) Platform {
+	if len(clusterData.Routes) > 0 {
+		return PlatformOpenShift
+	}
+	for n := range clusterData.Namespaces {
+		if strings.HasPrefix(n, "openshift-") {
+			return PlatformOpenShift
+		}
+	}
+	for _, p := range clusterData.Pods {
+		if _, ok := p.Annotations["openshift.io/scc"]; ok {
+			return PlatformOpenShift
+		}
+	}
+	return PlatformKubernetes
+}
+
+// shortNamespace drops the 'openshift-' prefix every OpenShift namespace
+// starts with, it's noisy
+func (p Platform) shortNamespace(namespace string) string {
+	if p == PlatformOpenShift {
+		return strings.TrimPrefix(namespace, "openshift-")
+	}
+	return namespace
+}
+
+// apiServerNamespace is the (short) namespace of the kube-apiserver static pods
+func (p Platform) apiServerNamespace() string {
+	if p == PlatformOpenShift {
+		return "kube-apiserver"
+	}
+	return "kube-system"
+}
+
+// staticPodName names the component of a static pod (owned by a Node)
+func (p Platform) staticPodName(pod corev1.Pod) string {
+	if name, ok := pod.Labels["app"]; ok {
+		return strings.TrimPrefix(name, "openshift-")
+	}
+	// kubeadm labels control plane static pods with their component
+	if name, ok := pod.Labels["component"]; ok {
+		return name
+	}
+	return strings.TrimSuffix(pod.Name, "-"+pod.Spec.NodeName)
+}
+
+// dnsPods are the namespace, labels and (target) port of the cluster DNS pods
+func (p Platform) dnsPods() (string, map[string]string, int) {
+	if p == PlatformOpenShift {
+		return "openshift-dns", map[string]string{"dns.operator.openshift.io/daemonset-dns": "default"}, 5353
+	}
+	return "kube-system", map[string]string{"k8s-app": "kube-dns"}, 53
+}
+
+// podSecurityLevel is the Pod Security Admission level enforced on a namespace
+func podSecurityLevel(namespace corev1.Namespace) string {
+	return namespace.Labels[podSecurityEnforceLabel]
+}
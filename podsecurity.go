@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+	psapolicy "k8s.io/pod-security-admission/policy"
+)
+
+// PodSecurity is how the pods of a component comply with the Pod Security
+// Standards, and with the levels set on their namespace
+type PodSecurity struct {
+	// Level is the most restrictive level all the pods satisfy, at the latest
+	// version of the standards
+	Level psaapi.Level `yaml:"level"`
+	// Violations of the levels more restrictive than Level
+	Violations []PodSecurityViolation `yaml:"violations,omitempty"`
+	// Namespace is the policy of the namespace, from its
+	// pod-security.kubernetes.io labels, privileged when they're not set. It's
+	// nil when the namespace is unknown.
+	Namespace *PodSecurityPolicy `yaml:"namespace,omitempty"`
+	// NamespaceViolations are the modes of the namespace policy the pods
+	// don't satisfy: enforce, audit or warn
+	NamespaceViolations []string `yaml:"namespaceViolations,omitempty"`
+}
+
+// PodSecurityPolicy has the level:version of each mode
+type PodSecurityPolicy struct {
+	Enforce string `yaml:"enforce"`
+	Audit   string `yaml:"audit"`
+	Warn    string `yaml:"warn"`
+}
+
+// PodSecurityViolation is a check of a level failed by some of the pods
+type PodSecurityViolation struct {
+	Level  psaapi.Level `yaml:"level"`
+	Reason string       `yaml:"reason"`
+	// Details are the offending values, e.g. container names
+	Details []string `yaml:"details,omitempty"`
+	Pods    []string `yaml:"pods"`
+}
+
+const (
+	podSecurityEnforce = "enforce"
+	podSecurityAudit   = "audit"
+	podSecurityWarn    = "warn"
+)
+
+// podSecurityLevels from the most to the least restrictive
+var podSecurityLevels = []psaapi.Level{psaapi.LevelRestricted, psaapi.LevelBaseline, psaapi.LevelPrivileged}
+
+// podSecurityEvaluator runs the checks of the pod-security-admission library
+var podSecurityEvaluator psapolicy.Evaluator
+
+func init() {
+	var err error
+	if podSecurityEvaluator, err = psapolicy.NewEvaluator(psapolicy.DefaultChecks()); err != nil {
+		log.Fatalf("Unable to create the Pod Security evaluator: %s", err)
+	}
+}
+
+// evaluatePodSecurity checks the pods of a component, all from the given
+// namespace, against the Pod Security Standards and the levels set on the
+// namespace, when it was collected
+func evaluatePodSecurity(pods []corev1.Pod, namespaceName string, namespaces map[string]corev1.Namespace) *PodSecurity {
+	ps := &PodSecurity{Level: psaapi.LevelPrivileged}
+
+	levelViolations := [][]PodSecurityViolation{}
+	for _, level := range podSecurityLevels {
+		violations := podSecurityViolations(pods, psaapi.LevelVersion{Level: level, Version: psaapi.LatestVersion()})
+		if len(violations) == 0 {
+			ps.Level = level
+			break
+		}
+		levelViolations = append(levelViolations, violations)
+	}
+	// the checks of a level include the ones of the less restrictive levels,
+	// a failed check is listed at the least restrictive level it fails
+	for i, violations := range levelViolations {
+		for _, v := range violations {
+			if i+1 < len(levelViolations) && slices.ContainsFunc(levelViolations[i+1], func(next PodSecurityViolation) bool {
+				return next.Reason == v.Reason
+			}) {
+				continue
+			}
+			ps.Violations = append(ps.Violations, v)
+		}
+	}
+
+	namespace, ok := namespaces[namespaceName]
+	if !ok {
+		return ps
+	}
+
+	policy, errs := psaapi.PolicyToEvaluate(namespace.Labels, psaapi.Policy{
+		Enforce: psaapi.LevelVersion{Level: psaapi.LevelPrivileged, Version: psaapi.LatestVersion()},
+		Audit:   psaapi.LevelVersion{Level: psaapi.LevelPrivileged, Version: psaapi.LatestVersion()},
+		Warn:    psaapi.LevelVersion{Level: psaapi.LevelPrivileged, Version: psaapi.LatestVersion()},
+	})
+	if len(errs) > 0 {
+		log.Warnf("Invalid Pod Security labels on namespace %s: %s", namespaceName, errs.ToAggregate())
+	}
+	ps.Namespace = &PodSecurityPolicy{
+		Enforce: policy.Enforce.String(),
+		Audit:   policy.Audit.String(),
+		Warn:    policy.Warn.String(),
+	}
+	for _, mode := range []struct {
+		name string
+		lv   psaapi.LevelVersion
+	}{
+		{podSecurityEnforce, policy.Enforce},
+		{podSecurityAudit, policy.Audit},
+		{podSecurityWarn, policy.Warn},
+	} {
+		if len(podSecurityViolations(pods, mode.lv)) > 0 {
+			ps.NamespaceViolations = append(ps.NamespaceViolations, mode.name)
+		}
+	}
+
+	return ps
+}
+
+// podSecurityViolations merges the failed checks of the pods by reason
+func podSecurityViolations(pods []corev1.Pod, lv psaapi.LevelVersion) []PodSecurityViolation {
+	violations := []PodSecurityViolation{}
+	for _, p := range pods {
+		for _, result := range podSecurityEvaluator.EvaluatePod(lv, &p.ObjectMeta, &p.Spec) {
+			if result.Allowed {
+				continue
+			}
+			i := slices.IndexFunc(violations, func(v PodSecurityViolation) bool {
+				return v.Reason == result.ForbiddenReason
+			})
+			if i == -1 {
+				violations = append(violations, PodSecurityViolation{Level: lv.Level, Reason: result.ForbiddenReason})
+				i = len(violations) - 1
+			}
+			if result.ForbiddenDetail != "" {
+				violations[i].Details = appendUnique(violations[i].Details, result.ForbiddenDetail)
+			}
+			violations[i].Pods = append(violations[i].Pods, p.Namespace+"/"+p.Name)
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Reason < violations[j].Reason
+	})
+	return violations
+}
+
+// String summarizes the pod security for reports, e.g.
+// "baseline (violates audit,warn)"
+func (ps *PodSecurity) String() string {
+	if ps == nil {
+		return ""
+	}
+	if len(ps.NamespaceViolations) == 0 {
+		return string(ps.Level)
+	}
+	return fmt.Sprintf("%s (violates %s)", ps.Level, strings.Join(ps.NamespaceViolations, ","))
+}
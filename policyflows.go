@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	directionIngress = "ingress"
+	directionEgress  = "egress"
+)
+
+// NetworkPolicyReport compares the observed flows with the network policies:
+// flows the policies would deny point at a misconfiguration, allow rules no
+// flow matched may be too permissive.
+type NetworkPolicyReport struct {
+	DeniedFlows []DeniedFlow `yaml:"deniedFlows"`
+	UnusedRules []PolicyRule `yaml:"unusedRules"`
+	// UnevaluatedFlows couldn't be checked, e.g. flows to a service address
+	// that wasn't translated to a pod
+	UnevaluatedFlows int `yaml:"unevaluatedFlows"`
+}
+
+// DeniedFlow aggregates the observed flows between two components that the
+// policies deny
+type DeniedFlow struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Port        string `yaml:"port"`
+	// Direction is the side denying the flow, ingress of the destination or
+	// egress of the source
+	Direction string `yaml:"direction"`
+	Reason    string `yaml:"reason"`
+	Flows     int    `yaml:"flows"`
+}
+
+// PolicyRule is an allow rule of a NetworkPolicy or AdminNetworkPolicy
+type PolicyRule struct {
+	Policy    string   `yaml:"policy"`
+	Direction string   `yaml:"direction"`
+	Rule      int      `yaml:"rule"`
+	Name      string   `yaml:"name,omitempty"`
+	Allows    []string `yaml:"allows"`
+}
+
+func (r PolicyRule) key() string {
+	return fmt.Sprintf("%s/%s/%d", r.Policy, r.Direction, r.Rule)
+}
+
+// flowEndpoint is one end of a flow, pod is nil outside the pod network
+type flowEndpoint struct {
+	pod     *corev1.Pod
+	address string
+	key     string
+}
+
+// policyVerdict is the outcome of the policies of one side of a flow
+type policyVerdict struct {
+	allowed bool
+	reason  string
+	// matched are the keys of the allow rules matching the flow
+	matched []string
+}
+
+// compareFlowsWithPolicies evaluates the flows against the policies
+func compareFlowsWithPolicies(policies *networkPolicies, flows []FlowData, clusterData ClusterData, serviceToComponent map[string]string, external *externalEndpoints) NetworkPolicyReport {
+	report := NetworkPolicyReport{
+		DeniedFlows: []DeniedFlow{},
+		UnusedRules: []PolicyRule{},
+	}
+
+	podsByName := make(map[string]*corev1.Pod)
+	podsByIP := make(map[string]*corev1.Pod)
+	for i := range clusterData.Pods {
+		p := &clusterData.Pods[i]
+		if p.Spec.HostNetwork {
+			// policies don't apply to host network pods, whose traffic is
+			// the node's
+			continue
+		}
+		podsByName[p.Namespace+"/"+p.Name] = p
+		if p.Status.PodIP != "" {
+			podsByIP[p.Status.PodIP] = p
+		}
+	}
+	endpoint := func(namespace, kind, name, ownerType, ownerName, hostName, address string) flowEndpoint {
+		e := flowEndpoint{address: address}
+		e.key = getComponentKey(platform.shortNamespace(namespace), ownerType, ownerName)
+		if v, ok := serviceToComponent[e.key]; ok {
+			e.key = v
+		} else if c, ok := external.component(kind, ownerType, ownerName, name, hostName, address); ok {
+			e.key = c.Key()
+		}
+		if p, ok := podsByName[namespace+"/"+name]; ok && (kind == "" || kind == "Pod") {
+			e.pod = p
+		} else if p, ok := podsByIP[address]; ok && kind != "Service" {
+			e.pod = p
+		}
+		return e
+	}
+
+	hits := make(map[string]int)
+	denied := make(map[string]*DeniedFlow)
+	for _, f := range flows {
+		if isReplyFlow(f) {
+			f = reverseFlow(f)
+		}
+		if f.DstK8S_Type == "Service" {
+			report.UnevaluatedFlows++
+			continue
+		}
+
+		src := endpoint(f.SrcK8S_Namespace, f.SrcK8S_Type, f.SrcK8S_Name, f.SrcK8S_OwnerType, f.SrcK8S_OwnerName, "", f.SrcAddr)
+		dst := endpoint(f.DstK8S_Namespace, f.DstK8S_Type, f.DstK8S_Name, f.DstK8S_OwnerType, f.DstK8S_OwnerName, f.DstK8S_HostName, f.DstAddr)
+		protocol := protocolNames[f.Proto]
+		port, _ := strconv.Atoi(f.DstPort)
+
+		for _, v := range []struct {
+			direction string
+			verdict   policyVerdict
+		}{
+			{directionEgress, policies.egressVerdict(src, dst, protocol, port)},
+			{directionIngress, policies.ingressVerdict(src, dst, protocol, port)},
+		} {
+			for _, m := range v.verdict.matched {
+				hits[m]++
+			}
+			if v.verdict.allowed {
+				continue
+			}
+			d := DeniedFlow{
+				Source:      src.key,
+				Destination: dst.key,
+				Port:        flowPort(f),
+				Direction:   v.direction,
+				Reason:      v.verdict.reason,
+			}
+			k := fmt.Sprintf("%s|%s|%s|%s|%s", d.Source, d.Destination, d.Port, d.Direction, d.Reason)
+			if _, ok := denied[k]; !ok {
+				denied[k] = &d
+			}
+			denied[k].Flows++
+		}
+	}
+
+	for _, d := range denied {
+		report.DeniedFlows = append(report.DeniedFlows, *d)
+	}
+	sort.Slice(report.DeniedFlows, func(i, j int) bool {
+		a, b := report.DeniedFlows[i], report.DeniedFlows[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Destination != b.Destination {
+			return a.Destination < b.Destination
+		}
+		return a.Port < b.Port
+	})
+
+	for _, r := range policies.allowRules() {
+		if hits[r.key()] == 0 {
+			report.UnusedRules = append(report.UnusedRules, r)
+		}
+	}
+
+	return report
+}
+
+// ingressVerdict tells if the policies of the destination allow a flow in
+func (n *networkPolicies) ingressVerdict(src, dst flowEndpoint, protocol string, port int) policyVerdict {
+	if dst.pod == nil {
+		return policyVerdict{allowed: true}
+	}
+
+admin:
+	for _, p := range n.adminSelecting(*dst.pod) {
+		for i, r := range p.Spec.Ingress {
+			if n.adminRuleMatches(r.From, r.Ports, src, dst, protocol, port) {
+				if v, decided := adminVerdict(p, r, i, directionIngress); decided {
+					return v
+				}
+				// passed on to the NetworkPolicies
+				break admin
+			}
+		}
+	}
+
+	return n.policyVerdict(n.isolating(*dst.pod, directionIngress), directionIngress, func(p networkingv1.NetworkPolicy, i int) bool {
+		r := p.Spec.Ingress[i]
+		return n.peersMatch(r.From, p.Namespace, src) && portsMatch(r.Ports, protocol, port, dst.pod)
+	})
+}
+
+// egressVerdict tells if the policies of the source allow a flow out
+func (n *networkPolicies) egressVerdict(src, dst flowEndpoint, protocol string, port int) policyVerdict {
+	if src.pod == nil {
+		return policyVerdict{allowed: true}
+	}
+
+admin:
+	for _, p := range n.adminSelecting(*src.pod) {
+		for i, r := range p.Spec.Egress {
+			if n.adminRuleMatches(r.To, r.Ports, dst, dst, protocol, port) {
+				if v, decided := adminVerdict(p, r, i, directionEgress); decided {
+					return v
+				}
+				// passed on to the NetworkPolicies
+				break admin
+			}
+		}
+	}
+
+	return n.policyVerdict(n.isolating(*src.pod, directionEgress), directionEgress, func(p networkingv1.NetworkPolicy, i int) bool {
+		r := p.Spec.Egress[i]
+		return n.peersMatch(r.To, p.Namespace, dst) && portsMatch(r.Ports, protocol, port, dst.pod)
+	})
+}
+
+// policyVerdict allows a flow when the pod isn't isolated in that direction,
+// or when a rule of an isolating policy matches
+func (n *networkPolicies) policyVerdict(isolating []networkingv1.NetworkPolicy, direction string, matches func(p networkingv1.NetworkPolicy, rule int) bool) policyVerdict {
+	if len(isolating) == 0 {
+		return policyVerdict{allowed: true}
+	}
+
+	v := policyVerdict{}
+	names := make([]string, 0, len(isolating))
+	for _, p := range isolating {
+		names = append(names, p.Namespace+"/"+p.Name)
+		rules := len(p.Spec.Ingress)
+		if direction == directionEgress {
+			rules = len(p.Spec.Egress)
+		}
+		for i := 0; i < rules; i++ {
+			if matches(p, i) {
+				v.allowed = true
+				v.matched = append(v.matched, PolicyRule{Policy: p.Namespace + "/" + p.Name, Direction: direction, Rule: i}.key())
+			}
+		}
+	}
+	if !v.allowed {
+		v.reason = "not allowed by " + strings.Join(names, ", ")
+	}
+	return v
+}
+
+// adminVerdict is the outcome of a matching AdminNetworkPolicy rule, Pass
+// leaves the decision to the NetworkPolicies
+func adminVerdict(p adminNetworkPolicy, r adminNetworkPolicyRule, i int, direction string) (policyVerdict, bool) {
+	rule := PolicyRule{Policy: "AdminNetworkPolicy/" + p.Name, Direction: direction, Rule: i}
+	switch r.Action {
+	case "Allow":
+		return policyVerdict{allowed: true, matched: []string{rule.key()}}, true
+	case "Deny":
+		reason := fmt.Sprintf("denied by %s rule %d", rule.Policy, i)
+		if r.Name != "" {
+			reason += fmt.Sprintf(" (%s)", r.Name)
+		}
+		return policyVerdict{reason: reason}, true
+	}
+	return policyVerdict{}, false
+}
+
+// isolating are the NetworkPolicies selecting a pod that isolate it in a
+// direction
+func (n *networkPolicies) isolating(pod corev1.Pod, direction string) []networkingv1.NetworkPolicy {
+	var isolating []networkingv1.NetworkPolicy
+	for _, p := range n.selecting(pod) {
+		ingress, egress := policyTypes(p)
+		if (direction == directionIngress && ingress) || (direction == directionEgress && egress) {
+			isolating = append(isolating, p)
+		}
+	}
+	return isolating
+}
+
+// peersMatch tells if a NetworkPolicy rule's peers include an endpoint, no
+// peers match everything
+func (n *networkPolicies) peersMatch(peers []networkingv1.NetworkPolicyPeer, namespace string, e flowEndpoint) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			if ipBlockContains(peer.IPBlock, e.address) {
+				return true
+			}
+			continue
+		}
+		if e.pod == nil {
+			continue
+		}
+		if peer.NamespaceSelector == nil && e.pod.Namespace != namespace {
+			continue
+		}
+		if peer.NamespaceSelector != nil && !selectorMatches(peer.NamespaceSelector, n.namespaceLabels(e.pod.Namespace)) {
+			continue
+		}
+		if peer.PodSelector == nil || selectorMatches(peer.PodSelector, e.pod.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *networkPolicies) adminRuleMatches(peers []adminNetworkPolicyPeer, ports []adminNetworkPolicyPort, peer, dst flowEndpoint, protocol string, port int) bool {
+	matched := false
+	for _, p := range peers {
+		switch {
+		case len(p.Networks) > 0:
+			for _, cidr := range p.Networks {
+				if prefixContains(cidr, peer.address) {
+					matched = true
+				}
+			}
+		case peer.pod != nil && (p.Namespaces != nil || p.Pods != nil):
+			matched = matched || n.peerMatchesPod(p, *peer.pod)
+		}
+	}
+	if !matched {
+		return false
+	}
+	if len(ports) == 0 {
+		return true
+	}
+
+	for _, p := range ports {
+		switch {
+		case p.PortNumber != nil:
+			if string(p.PortNumber.Protocol) == protocol && int(p.PortNumber.Port) == port {
+				return true
+			}
+		case p.PortRange != nil:
+			if string(p.PortRange.Protocol) == protocol && port >= int(p.PortRange.Start) && port <= int(p.PortRange.End) {
+				return true
+			}
+		case p.NamedPort != nil:
+			if namedPort(dst.pod, *p.NamedPort, protocol) == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portsMatch tells if a NetworkPolicy rule's ports include a flow's, no ports
+// match everything. Named ports are those of the destination pod.
+func portsMatch(ports []networkingv1.NetworkPolicyPort, protocol string, port int, dst *corev1.Pod) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		portProtocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			portProtocol = *p.Protocol
+		}
+		if string(portProtocol) != protocol {
+			continue
+		}
+		switch {
+		case p.Port == nil:
+			return true
+		case p.Port.Type == intstr.Int && p.EndPort != nil:
+			if port >= p.Port.IntValue() && port <= int(*p.EndPort) {
+				return true
+			}
+		case p.Port.Type == intstr.Int:
+			if port == p.Port.IntValue() {
+				return true
+			}
+		default:
+			if namedPort(dst, p.Port.StrVal, protocol) == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// namedPort resolves a container port name of a pod, 0 when unknown
+func namedPort(pod *corev1.Pod, name string, protocol string) int {
+	if pod == nil {
+		return 0
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			portProtocol := p.Protocol
+			if portProtocol == "" {
+				portProtocol = corev1.ProtocolTCP
+			}
+			if p.Name == name && string(portProtocol) == protocol {
+				return int(p.ContainerPort)
+			}
+		}
+	}
+	return 0
+}
+
+func ipBlockContains(block *networkingv1.IPBlock, address string) bool {
+	if !prefixContains(block.CIDR, address) {
+		return false
+	}
+	for _, except := range block.Except {
+		if prefixContains(except, address) {
+			return false
+		}
+	}
+	return true
+}
+
+func prefixContains(cidr string, address string) bool {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(addr.Unmap())
+}
+
+// allowRules are the rules of all policies allowing traffic
+func (n *networkPolicies) allowRules() []PolicyRule {
+	var rules []PolicyRule
+	namespaces := maps.Keys(n.byNamespace)
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		for _, p := range n.byNamespace[namespace] {
+			policy := p.Namespace + "/" + p.Name
+			for i, r := range p.Spec.Ingress {
+				rules = append(rules, PolicyRule{
+					Policy:    policy,
+					Direction: directionIngress,
+					Rule:      i,
+					Allows:    describePolicyRule(r.From, r.Ports, p.Namespace),
+				})
+			}
+			for i, r := range p.Spec.Egress {
+				rules = append(rules, PolicyRule{
+					Policy:    policy,
+					Direction: directionEgress,
+					Rule:      i,
+					Allows:    describePolicyRule(r.To, r.Ports, p.Namespace),
+				})
+			}
+		}
+	}
+
+	for _, p := range n.admin {
+		for _, direction := range []string{directionIngress, directionEgress} {
+			adminRules, verb := p.Spec.Ingress, "ingress from"
+			if direction == directionEgress {
+				adminRules, verb = p.Spec.Egress, "egress to"
+			}
+			for i, r := range adminRules {
+				if r.Action != "Allow" {
+					continue
+				}
+				peers := r.From
+				if direction == directionEgress {
+					peers = r.To
+				}
+				rules = append(rules, PolicyRule{
+					Policy:    "AdminNetworkPolicy/" + p.Name,
+					Direction: direction,
+					Rule:      i,
+					Name:      r.Name,
+					Allows:    []string{describeAdminRule(p, r, verb, peers)},
+				})
+			}
+		}
+	}
+
+	return rules
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	securityv1 "github.com/openshift/api/security/v1"
+	"github.com/sfowl/pod-checker/pkg/sachecker"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	sccAnnotation = "openshift.io/scc"
+	// uidRangeAnnotation is the <first>/<size> UIDs allocated to a namespace,
+	// used by MustRunAsRange SCCs without a range of their own
+	uidRangeAnnotation = "openshift.io/sa.scc.uid-range"
+)
+
+// SCCInfo is what a SecurityContextConstraints allows
+type SCCInfo struct {
+	Name                     string              `yaml:"name"`
+	Priority                 *int32              `yaml:"priority,omitempty"`
+	AllowPrivilegedContainer bool                `yaml:"allowPrivilegedContainer"`
+	AllowPrivilegeEscalation bool                `yaml:"allowPrivilegeEscalation"`
+	AllowHostNetwork         bool                `yaml:"allowHostNetwork"`
+	AllowHostPorts           bool                `yaml:"allowHostPorts"`
+	AllowHostPID             bool                `yaml:"allowHostPID"`
+	AllowHostIPC             bool                `yaml:"allowHostIPC"`
+	AllowHostDirVolumePlugin bool                `yaml:"allowHostDirVolumePlugin"`
+	AllowedCapabilities      []corev1.Capability `yaml:"allowedCapabilities,omitempty"`
+	DefaultAddCapabilities   []corev1.Capability `yaml:"defaultAddCapabilities,omitempty"`
+	RequiredDropCapabilities []corev1.Capability `yaml:"requiredDropCapabilities,omitempty"`
+	Volumes                  []securityv1.FSType `yaml:"volumes"`
+	RunAsUser                string              `yaml:"runAsUser"`
+	SELinuxContext           string              `yaml:"seLinuxContext"`
+	FSGroup                  string              `yaml:"fsGroup"`
+	SupplementalGroups       string              `yaml:"supplementalGroups"`
+	ReadOnlyRootFilesystem   bool                `yaml:"readOnlyRootFilesystem"`
+	SeccompProfiles          []string            `yaml:"seccompProfiles,omitempty"`
+	AllowedUnsafeSysctls     []string            `yaml:"allowedUnsafeSysctls,omitempty"`
+	// Points rank the privileges allowed, the fewer the more restrictive
+	Points int `yaml:"points"`
+}
+
+// ComponentSCC explains the SCC of the pods of a component
+type ComponentSCC struct {
+	// Current is the SCC that admitted the pods, nil when it wasn't found
+	Current *SCCInfo `yaml:"current,omitempty"`
+	// Usable are the SCCs the service account may use, through their users
+	// and groups or the RBAC use verb, most restrictive first. They're only
+	// resolved when RBAC is collected.
+	Usable []string `yaml:"usable,omitempty"`
+	// MoreRestrictive are the usable SCCs, more restrictive than the current
+	// one, that would admit all the pods
+	MoreRestrictive []string `yaml:"moreRestrictive,omitempty"`
+	// Unverified are more restrictive SCCs that would admit the pods, but
+	// assign them a UID while they run as the user of their image, root for
+	// most anyuid workloads
+	Unverified []string `yaml:"unverified,omitempty"`
+}
+
+func newSCCInfo(scc securityv1.SecurityContextConstraints) SCCInfo {
+	info := SCCInfo{
+		Name:                     scc.Name,
+		Priority:                 scc.Priority,
+		AllowPrivilegedContainer: scc.AllowPrivilegedContainer,
+		// privilege escalation is allowed when not set
+		AllowPrivilegeEscalation: scc.AllowPrivilegeEscalation == nil || *scc.AllowPrivilegeEscalation,
+		AllowHostNetwork:         scc.AllowHostNetwork,
+		AllowHostPorts:           scc.AllowHostPorts,
+		AllowHostPID:             scc.AllowHostPID,
+		AllowHostIPC:             scc.AllowHostIPC,
+		AllowHostDirVolumePlugin: scc.AllowHostDirVolumePlugin,
+		AllowedCapabilities:      scc.AllowedCapabilities,
+		DefaultAddCapabilities:   scc.DefaultAddCapabilities,
+		RequiredDropCapabilities: scc.RequiredDropCapabilities,
+		Volumes:                  scc.Volumes,
+		RunAsUser:                string(scc.RunAsUser.Type),
+		SELinuxContext:           string(scc.SELinuxContext.Type),
+		FSGroup:                  string(scc.FSGroup.Type),
+		SupplementalGroups:       string(scc.SupplementalGroups.Type),
+		ReadOnlyRootFilesystem:   scc.ReadOnlyRootFilesystem,
+		SeccompProfiles:          scc.SeccompProfiles,
+		AllowedUnsafeSysctls:     scc.AllowedUnsafeSysctls,
+	}
+	info.Points = sccPoints(scc)
+	return info
+}
+
+// sccPoints is a simplified version of the restrictiveness ranking of the
+// SCC admission plugin: privileged containers and host volumes weigh the
+// most, then the users containers may run as, host namespaces and
+// capabilities
+func sccPoints(scc securityv1.SecurityContextConstraints) int {
+	points := 0
+	if scc.AllowPrivilegedContainer {
+		points += 200000
+	}
+	if allowsVolume(scc, securityv1.FSTypeHostPath) {
+		points += 100000
+	}
+	switch scc.RunAsUser.Type {
+	case securityv1.RunAsUserStrategyRunAsAny:
+		points += 40000
+	case securityv1.RunAsUserStrategyMustRunAsNonRoot:
+		points += 30000
+	case securityv1.RunAsUserStrategyMustRunAsRange:
+		points += 20000
+	case securityv1.RunAsUserStrategyMustRunAs:
+		points += 10000
+	}
+	for _, allowed := range []bool{scc.AllowHostNetwork, scc.AllowHostPID, scc.AllowHostIPC, scc.AllowHostPorts} {
+		if allowed {
+			points += 1000
+		}
+	}
+	if scc.SELinuxContext.Type == securityv1.SELinuxStrategyRunAsAny {
+		points += 1000
+	}
+	if slices.Contains(scc.AllowedCapabilities, securityv1.AllowAllCapabilities) {
+		points += 4000
+	} else {
+		points += 10 * len(scc.AllowedCapabilities)
+	}
+	points += 300 * len(scc.DefaultAddCapabilities)
+	if slices.Contains(scc.RequiredDropCapabilities, "ALL") {
+		points -= 1000
+	} else {
+		points -= 10 * len(scc.RequiredDropCapabilities)
+	}
+	if scc.AllowPrivilegeEscalation == nil || *scc.AllowPrivilegeEscalation {
+		points += 100
+	}
+	return points
+}
+
+// sortSCCs sorts SCCs in the order the admission plugin tries them: highest
+// priority first, then from the most to the least restrictive
+func sortSCCs(sccs []SCCInfo) {
+	priority := func(info SCCInfo) int32 {
+		if info.Priority == nil {
+			return 0
+		}
+		return *info.Priority
+	}
+	sort.Slice(sccs, func(i, j int) bool {
+		if priority(sccs[i]) != priority(sccs[j]) {
+			return priority(sccs[i]) > priority(sccs[j])
+		}
+		if sccs[i].Points != sccs[j].Points {
+			return sccs[i].Points < sccs[j].Points
+		}
+		return sccs[i].Name < sccs[j].Name
+	})
+}
+
+// componentSCC finds the SCC of the pods, and the other SCCs their service
+// account may use in namespace. permissions is nil when RBAC wasn't
+// collected.
+func componentSCC(c Component, sccs []securityv1.SecurityContextConstraints, namespace string, namespaces map[string]corev1.Namespace, permissions *sachecker.Permissions) *ComponentSCC {
+	current := c.Pods[0].Annotations[sccAnnotation]
+	if current == "" {
+		current = c.SCC
+	}
+
+	cs := &ComponentSCC{}
+	var currentPoints int
+	for _, scc := range sccs {
+		if scc.Name == current {
+			info := newSCCInfo(scc)
+			cs.Current = &info
+			currentPoints = info.Points
+		}
+	}
+	if permissions == nil {
+		return cs
+	}
+
+	usable := []SCCInfo{}
+	serviceAccount := serviceAccountName(c.Pods[0])
+	for _, scc := range sccs {
+		if canUseSCC(scc, namespace, serviceAccount, permissions) {
+			usable = append(usable, newSCCInfo(scc))
+		}
+	}
+	sortSCCs(usable)
+	for _, info := range usable {
+		cs.Usable = append(cs.Usable, info.Name)
+		if cs.Current == nil || info.Points >= currentPoints {
+			continue
+		}
+		scc := sccs[slices.IndexFunc(sccs, func(s securityv1.SecurityContextConstraints) bool { return s.Name == info.Name })]
+		admitted := true
+		for _, p := range c.Pods {
+			if len(sccViolations(scc, p, namespaces[namespace])) > 0 {
+				admitted = false
+				break
+			}
+		}
+		switch {
+		case !admitted:
+		case cs.Current.RunAsUser == string(securityv1.RunAsUserStrategyRunAsAny) &&
+			info.RunAsUser != string(securityv1.RunAsUserStrategyRunAsAny) && !setUsers(c.Pods):
+			cs.Unverified = append(cs.Unverified, info.Name)
+		default:
+			cs.MoreRestrictive = append(cs.MoreRestrictive, info.Name)
+		}
+	}
+	return cs
+}
+
+// setUsers tells if all the containers of the pods set runAsUser or
+// runAsNonRoot, themselves or through their pod
+func setUsers(pods []corev1.Pod) bool {
+	for _, p := range pods {
+		podSC := p.Spec.SecurityContext
+		if podSC != nil && (podSC.RunAsUser != nil || podSC.RunAsNonRoot != nil) {
+			continue
+		}
+		for _, c := range podContainers(p) {
+			if sc := c.SecurityContext; sc == nil || (sc.RunAsUser == nil && sc.RunAsNonRoot == nil) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// canUseSCC tells if a service account is one of the users or groups of an
+// SCC, or is granted the use verb on it
+func canUseSCC(scc securityv1.SecurityContextConstraints, namespace string, serviceAccount string, permissions *sachecker.Permissions) bool {
+	if slices.Contains(scc.Users, fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount)) {
+		return true
+	}
+	for _, g := range []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"} {
+		if slices.Contains(scc.Groups, g) {
+			return true
+		}
+	}
+	return permissions.Allows(namespace, securityAPIGroup, "securitycontextconstraints", scc.Name, "use")
+}
+
+// sccViolations lists why an SCC wouldn't admit a pod. Only the settings the
+// pod requests are checked, the ones the SCC defaults are assumed to suit
+// the pod. componentSCC flags the UIDs it can't verify.
+func sccViolations(scc securityv1.SecurityContextConstraints, p corev1.Pod, namespace corev1.Namespace) []string {
+	violations := []string{}
+	if p.Spec.HostNetwork && !scc.AllowHostNetwork {
+		violations = append(violations, "hostNetwork")
+	}
+	if p.Spec.HostPID && !scc.AllowHostPID {
+		violations = append(violations, "hostPID")
+	}
+	if p.Spec.HostIPC && !scc.AllowHostIPC {
+		violations = append(violations, "hostIPC")
+	}
+	if hasHostPorts(p) && !scc.AllowHostPorts {
+		violations = append(violations, "hostPorts")
+	}
+
+	for _, v := range p.Spec.Volumes {
+		fsType := volumeFSType(v)
+		if fsType == "" {
+			continue
+		}
+		if !allowsVolume(scc, fsType) || (fsType == securityv1.FSTypeHostPath && !scc.AllowHostDirVolumePlugin) {
+			violations = append(violations, fmt.Sprintf("volume %s of type %s", v.Name, fsType))
+		}
+	}
+
+	if v := seccompViolation(scc, p.Spec.SecurityContext, nil); v != "" {
+		violations = append(violations, v)
+	}
+	// the pod's UID only applies to the containers without their own
+	podUID := false
+	for _, c := range podContainers(p) {
+		sc := c.SecurityContext
+		if sc == nil || sc.RunAsUser == nil {
+			podUID = true
+		}
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged && !scc.AllowPrivilegedContainer {
+			violations = append(violations, fmt.Sprintf("container %s is privileged", c.Name))
+		}
+		if sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation && scc.AllowPrivilegeEscalation != nil && !*scc.AllowPrivilegeEscalation {
+			violations = append(violations, fmt.Sprintf("container %s allows privilege escalation", c.Name))
+		}
+		if sc.ReadOnlyRootFilesystem != nil && !*sc.ReadOnlyRootFilesystem && scc.ReadOnlyRootFilesystem {
+			violations = append(violations, fmt.Sprintf("container %s has a writable root filesystem", c.Name))
+		}
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				switch {
+				case slices.Contains(scc.RequiredDropCapabilities, capability) || slices.Contains(scc.RequiredDropCapabilities, "ALL"):
+					violations = append(violations, fmt.Sprintf("container %s adds %s, which must be dropped", c.Name, capability))
+				case !slices.Contains(scc.AllowedCapabilities, securityv1.AllowAllCapabilities) &&
+					!slices.Contains(scc.AllowedCapabilities, capability) && !slices.Contains(scc.DefaultAddCapabilities, capability):
+					violations = append(violations, fmt.Sprintf("container %s adds %s", c.Name, capability))
+				}
+			}
+		}
+		if v := runAsUserViolation(scc, p.Spec.SecurityContext, sc, namespace); v != "" {
+			violations = append(violations, fmt.Sprintf("container %s %s", c.Name, v))
+		}
+		if v := seccompViolation(scc, p.Spec.SecurityContext, sc); v != "" {
+			violations = append(violations, fmt.Sprintf("container %s %s", c.Name, v))
+		}
+	}
+	if podUID {
+		if v := runAsUserViolation(scc, p.Spec.SecurityContext, nil, namespace); v != "" {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// runAsUserViolation checks the UID requested by a pod, or by a container
+// when sc is set
+func runAsUserViolation(scc securityv1.SecurityContextConstraints, podSC *corev1.PodSecurityContext, sc *corev1.SecurityContext, namespace corev1.Namespace) string {
+	var uid *int64
+	switch {
+	case sc != nil:
+		uid = sc.RunAsUser
+	case podSC != nil:
+		uid = podSC.RunAsUser
+	}
+	if uid == nil {
+		return ""
+	}
+
+	switch scc.RunAsUser.Type {
+	case securityv1.RunAsUserStrategyMustRunAs:
+		if scc.RunAsUser.UID != nil && *uid != *scc.RunAsUser.UID {
+			return fmt.Sprintf("runs as UID %d", *uid)
+		}
+	case securityv1.RunAsUserStrategyMustRunAsNonRoot:
+		if *uid == 0 {
+			return "runs as root"
+		}
+	case securityv1.RunAsUserStrategyMustRunAsRange:
+		min, max, ok := scc.RunAsUser.UIDRangeMin, scc.RunAsUser.UIDRangeMax, true
+		if min == nil || max == nil {
+			min, max, ok = namespaceUIDRange(namespace)
+		}
+		if ok && (*uid < *min || *uid > *max) {
+			return fmt.Sprintf("runs as UID %d", *uid)
+		}
+	}
+	return ""
+}
+
+// namespaceUIDRange parses the <first>/<size> UID range of a namespace
+func namespaceUIDRange(namespace corev1.Namespace) (*int64, *int64, bool) {
+	first, size, found := strings.Cut(namespace.Annotations[uidRangeAnnotation], "/")
+	if !found {
+		return nil, nil, false
+	}
+	min, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+	max := min + n - 1
+	return &min, &max, true
+}
+
+// seccompViolation checks the seccomp profile requested by a pod, or by a
+// container when sc is set
+func seccompViolation(scc securityv1.SecurityContextConstraints, podSC *corev1.PodSecurityContext, sc *corev1.SecurityContext) string {
+	if len(scc.SeccompProfiles) == 0 || slices.Contains(scc.SeccompProfiles, "*") {
+		return ""
+	}
+	var profile *corev1.SeccompProfile
+	switch {
+	case sc != nil:
+		profile = sc.SeccompProfile
+	case podSC != nil:
+		profile = podSC.SeccompProfile
+	}
+	if profile == nil {
+		return ""
+	}
+
+	var name string
+	switch profile.Type {
+	case corev1.SeccompProfileTypeRuntimeDefault:
+		if slices.Contains(scc.SeccompProfiles, "runtime/default") || slices.Contains(scc.SeccompProfiles, "docker/default") {
+			return ""
+		}
+		name = "runtime/default"
+	case corev1.SeccompProfileTypeLocalhost:
+		if profile.LocalhostProfile != nil {
+			name = "localhost/" + *profile.LocalhostProfile
+		}
+		if slices.Contains(scc.SeccompProfiles, name) || slices.Contains(scc.SeccompProfiles, "localhost/*") {
+			return ""
+		}
+	default:
+		name = "unconfined"
+		if slices.Contains(scc.SeccompProfiles, name) {
+			return ""
+		}
+	}
+	return "uses seccomp profile " + name
+}
+
+func allowsVolume(scc securityv1.SecurityContextConstraints, fsType securityv1.FSType) bool {
+	return slices.Contains(scc.Volumes, securityv1.FSTypeAll) || slices.Contains(scc.Volumes, fsType)
+}
+
+// volumeFSType is the SCC volume type of a pod volume, empty for the types
+// not listed here
+func volumeFSType(v corev1.Volume) securityv1.FSType {
+	switch {
+	case v.HostPath != nil:
+		return securityv1.FSTypeHostPath
+	case v.EmptyDir != nil:
+		return securityv1.FSTypeEmptyDir
+	case v.Secret != nil:
+		return securityv1.FSTypeSecret
+	case v.ConfigMap != nil:
+		return securityv1.FSTypeConfigMap
+	case v.DownwardAPI != nil:
+		return securityv1.FSTypeDownwardAPI
+	case v.Projected != nil:
+		return securityv1.FSProjected
+	case v.PersistentVolumeClaim != nil:
+		return securityv1.FSTypePersistentVolumeClaim
+	case v.Ephemeral != nil:
+		return securityv1.FSTypeEphemeral
+	case v.CSI != nil:
+		return securityv1.FSTypeCSI
+	case v.NFS != nil:
+		return securityv1.FSTypeNFS
+	case v.ISCSI != nil:
+		return securityv1.FSTypeISCSI
+	case v.FlexVolume != nil:
+		return securityv1.FSTypeFlexVolume
+	case v.GitRepo != nil:
+		return securityv1.FSTypeGitRepo
+	}
+	return ""
+}
+
+// String summarizes the SCCs for reports, e.g. "anyuid (could use restricted-v2)"
+func (cs *ComponentSCC) String() string {
+	if cs == nil || cs.Current == nil {
+		return ""
+	}
+	alternatives := []string{}
+	if len(cs.MoreRestrictive) > 0 {
+		alternatives = append(alternatives, "could use "+strings.Join(cs.MoreRestrictive, ","))
+	}
+	if len(cs.Unverified) > 0 {
+		alternatives = append(alternatives, "unverified "+strings.Join(cs.Unverified, ","))
+	}
+	if len(alternatives) == 0 {
+		return cs.Current.Name
+	}
+	return fmt.Sprintf("%s (%s)", cs.Current.Name, strings.Join(alternatives, ", "))
+}
@@ -30,6 +30,9 @@ type ComponentSecurityContext struct {
 }
 
 func (c *ComponentSecurityContext) fromPodSC(sc *corev1.PodSecurityContext) {
+	if sc == nil {
+		return
+	}
 	c.FSGroup = sc.FSGroup
 	c.FSGroupChangePolicy = sc.FSGroupChangePolicy
 	if sc.RunAsNonRoot != nil {
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServiceInfo is the part of a Service selecting a component that matters
+// for its exposure
+type ServiceInfo struct {
+	Name                string             `yaml:"name"`
+	Type                corev1.ServiceType `yaml:"type"`
+	Ports               []ServicePortInfo  `yaml:"ports"`
+	Selector            map[string]string  `yaml:"selector,omitempty"`
+	ExternalIPs         []string           `yaml:"externalIPs,omitempty"`
+	LoadBalancerIngress []string           `yaml:"loadBalancerIngress,omitempty"`
+}
+
+type ServicePortInfo struct {
+	Name        string          `yaml:"name,omitempty"`
+	Protocol    corev1.Protocol `yaml:"protocol"`
+	Port        int32           `yaml:"port"`
+	TargetPort  string          `yaml:"targetPort"`
+	NodePort    int32           `yaml:"nodePort,omitempty"`
+	AppProtocol string          `yaml:"appProtocol,omitempty"`
+}
+
+// RouteInfo is a Route, Ingress rule or HTTPRoute exposing one of the
+// component's services outside the cluster
+type RouteInfo struct {
+	Kind    string `yaml:"kind"`
+	Name    string `yaml:"name"`
+	Host    string `yaml:"host,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+	Service string `yaml:"service"`
+	// TLSTermination is edge, passthrough or reencrypt for Routes, edge for
+	// Ingresses with TLS, none when the route is plain HTTP. It's empty for
+	// HTTPRoutes, whose TLS is configured on the Gateway.
+	TLSTermination                string `yaml:"tlsTermination,omitempty"`
+	InsecureEdgeTerminationPolicy string `yaml:"insecureEdgeTerminationPolicy,omitempty"`
+	// Certificate served: custom when set on the route, default for the
+	// ingress controller's, backend when TLS is passed through to the pods
+	Certificate    string `yaml:"certificate,omitempty"`
+	WildcardPolicy string `yaml:"wildcardPolicy,omitempty"`
+	// PlainHTTP is set when the component can be reached over plain HTTP
+	// from outside the cluster
+	PlainHTTP bool `yaml:"plainHTTP"`
+	// TargetPort is the protocol/port of the pods a Route sends traffic to,
+	// e.g. TCP/8443, resolved through the service. It's empty when it can't
+	// be resolved, and for Ingresses and HTTPRoutes.
+	TargetPort string `yaml:"targetPort,omitempty"`
+}
+
+const (
+	tlsTerminationNone = "none"
+
+	certificateCustom  = "custom"
+	certificateDefault = "default"
+	certificateBackend = "backend"
+)
+
+// Exposure mechanisms besides routes, whose kind is used
+const (
+	exposedByNodePort     = "NodePort"
+	exposedByLoadBalancer = "LoadBalancer"
+	exposedByExternalIP   = "ExternalIP"
+	exposedByHostPort     = "HostPort"
+)
+
+func newServiceInfo(s corev1.Service) ServiceInfo {
+	info := ServiceInfo{
+		Name:        s.Name,
+		Type:        s.Spec.Type,
+		Ports:       []ServicePortInfo{},
+		Selector:    s.Spec.Selector,
+		ExternalIPs: s.Spec.ExternalIPs,
+	}
+	if info.Type == "" {
+		info.Type = corev1.ServiceTypeClusterIP
+	}
+	for _, p := range s.Spec.Ports {
+		port := ServicePortInfo{
+			Name:       p.Name,
+			Protocol:   p.Protocol,
+			Port:       p.Port,
+			TargetPort: p.TargetPort.String(),
+			NodePort:   p.NodePort,
+		}
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		if p.AppProtocol != nil {
+			port.AppProtocol = *p.AppProtocol
+		}
+		info.Ports = append(info.Ports, port)
+	}
+	for _, i := range s.Status.LoadBalancer.Ingress {
+		if i.Hostname != "" {
+			info.LoadBalancerIngress = append(info.LoadBalancerIngress, i.Hostname)
+		} else if i.IP != "" {
+			info.LoadBalancerIngress = append(info.LoadBalancerIngress, i.IP)
+		}
+	}
+	return info
+}
+
+// String formats the service for the TSV, e.g.
+// "web NodePort TCP/80->8080 node:30080"
+func (s ServiceInfo) String() string {
+	parts := []string{s.Name, string(s.Type)}
+	for _, p := range s.Ports {
+		port := fmt.Sprintf("%s/%d->%s", p.Protocol, p.Port, p.TargetPort)
+		if p.NodePort != 0 && s.Type != corev1.ServiceTypeClusterIP {
+			port += fmt.Sprintf(" node:%d", p.NodePort)
+		}
+		if p.AppProtocol != "" {
+			port += " " + p.AppProtocol
+		}
+		parts = append(parts, port)
+	}
+	if len(s.ExternalIPs) > 0 {
+		parts = append(parts, "externalIPs:"+strings.Join(s.ExternalIPs, ","))
+	}
+	if len(s.LoadBalancerIngress) > 0 {
+		parts = append(parts, "lb:"+strings.Join(s.LoadBalancerIngress, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func newRouteInfo(r routev1.Route) RouteInfo {
+	info := RouteInfo{
+		Kind:           "Route",
+		Name:           r.Name,
+		Host:           r.Spec.Host,
+		Path:           r.Spec.Path,
+		Service:        r.Spec.To.Name,
+		WildcardPolicy: string(r.Spec.WildcardPolicy),
+	}
+	if info.WildcardPolicy == string(routev1.WildcardPolicyNone) {
+		info.WildcardPolicy = ""
+	}
+	if r.Spec.TLS == nil || r.Spec.TLS.Termination == "" {
+		info.TLSTermination = tlsTerminationNone
+		info.PlainHTTP = true
+		return info
+	}
+
+	info.TLSTermination = string(r.Spec.TLS.Termination)
+	info.InsecureEdgeTerminationPolicy = string(r.Spec.TLS.InsecureEdgeTerminationPolicy)
+	// passthrough routes can't serve HTTP, the router doesn't see the traffic
+	info.PlainHTTP = r.Spec.TLS.Termination != routev1.TLSTerminationPassthrough &&
+		r.Spec.TLS.InsecureEdgeTerminationPolicy == routev1.InsecureEdgeTerminationPolicyAllow
+	switch {
+	case r.Spec.TLS.Termination == routev1.TLSTerminationPassthrough:
+		info.Certificate = certificateBackend
+	case r.Spec.TLS.Certificate != "":
+		info.Certificate = certificateCustom
+	default:
+		info.Certificate = certificateDefault
+	}
+	return info
+}
+
+// newIngressRouteInfos lists the hosts and paths of an Ingress leading to a
+// service
+func newIngressRouteInfos(i networkingv1.Ingress, service string) []RouteInfo {
+	info := func(host, path string) RouteInfo {
+		r := RouteInfo{
+			Kind:    "Ingress",
+			Name:    i.Name,
+			Host:    host,
+			Path:    path,
+			Service: service,
+		}
+		secret, ok := ingressTLSSecret(i, host)
+		if !ok {
+			r.TLSTermination = tlsTerminationNone
+			r.PlainHTTP = true
+			return r
+		}
+		// most ingress controllers also serve TLS hosts over HTTP unless
+		// told to redirect, that's controller specific and not checked here
+		r.TLSTermination = string(routev1.TLSTerminationEdge)
+		r.Certificate = certificateDefault
+		if secret != "" {
+			r.Certificate = certificateCustom
+		}
+		return r
+	}
+
+	infos := []RouteInfo{}
+	if b := i.Spec.DefaultBackend; b != nil && b.Service != nil && b.Service.Name == service {
+		infos = append(infos, info("", ""))
+	}
+	for _, rule := range i.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			if p.Backend.Service != nil && p.Backend.Service.Name == service {
+				infos = append(infos, info(rule.Host, p.Path))
+			}
+		}
+	}
+	return infos
+}
+
+// ingressTLSSecret finds the TLS entry of an Ingress covering a host, by
+// exact or wildcard host first, then the first entry without hosts which
+// applies to all of them. The secret is "" for the controller's default
+// certificate.
+func ingressTLSSecret(i networkingv1.Ingress, host string) (string, bool) {
+	for _, t := range i.Spec.TLS {
+		for _, h := range t.Hosts {
+			if ingressHostMatches(h, host) {
+				return t.SecretName, true
+			}
+		}
+	}
+	for _, t := range i.Spec.TLS {
+		if len(t.Hosts) == 0 {
+			return t.SecretName, true
+		}
+	}
+	return "", false
+}
+
+// ingressHostMatches tells if host is pattern, or matches a wildcard pattern
+// such as *.example.com on its first label
+func ingressHostMatches(pattern, host string) bool {
+	if pattern == host {
+		return true
+	}
+	if !strings.HasPrefix(pattern, "*.") || !strings.HasSuffix(host, pattern[1:]) {
+		return false
+	}
+	label := strings.TrimSuffix(host, pattern[1:])
+	return label != "" && !strings.Contains(label, ".")
+}
+
+// newHTTPRouteInfos lists the hostnames of a Gateway API HTTPRoute, TLS is
+// configured on its Gateway
+func newHTTPRouteInfos(r unstructured.Unstructured, service string) []RouteInfo {
+	hostnames, _, _ := unstructured.NestedStringSlice(r.Object, "spec", "hostnames")
+	if len(hostnames) == 0 {
+		hostnames = []string{""}
+	}
+	infos := []RouteInfo{}
+	for _, h := range hostnames {
+		infos = append(infos, RouteInfo{
+			Kind:    "HTTPRoute",
+			Name:    r.GetName(),
+			Host:    h,
+			Service: service,
+		})
+	}
+	return infos
+}
+
+// String formats the route for the TSV, e.g.
+// "Route web shop.example.com/cart edge insecure:Allow"
+func (r RouteInfo) String() string {
+	parts := []string{r.Kind, r.Name, r.Host + r.Path}
+	if r.TLSTermination != "" {
+		parts = append(parts, r.TLSTermination)
+	}
+	if r.Certificate != "" {
+		parts = append(parts, "cert:"+r.Certificate)
+	}
+	if r.InsecureEdgeTerminationPolicy != "" {
+		parts = append(parts, "insecure:"+r.InsecureEdgeTerminationPolicy)
+	}
+	if r.WildcardPolicy != "" {
+		parts = append(parts, "wildcard:"+r.WildcardPolicy)
+	}
+	return strings.Join(parts, " ")
+}
+
+// serviceExposure lists how a service is reachable from outside the cluster.
+// LoadBalancer services also get node ports, unless allocateLoadBalancerNodePorts
+// is false.
+func serviceExposure(s corev1.Service) []string {
+	exposure := []string{}
+	switch s.Spec.Type {
+	case corev1.ServiceTypeNodePort:
+		exposure = append(exposure, exposedByNodePort)
+	case corev1.ServiceTypeLoadBalancer:
+		exposure = append(exposure, exposedByLoadBalancer)
+		if s.Spec.AllocateLoadBalancerNodePorts == nil || *s.Spec.AllocateLoadBalancerNodePorts {
+			exposure = append(exposure, exposedByNodePort)
+		}
+	}
+	if len(s.Spec.ExternalIPs) > 0 {
+		exposure = append(exposure, exposedByExternalIP)
+	}
+	return exposure
+}
+
+// hasHostPorts tells if a container of the pod binds a port on its node
+func hasHostPorts(p corev1.Pod) bool {
+	for _, containers := range [][]corev1.Container{p.Spec.InitContainers, p.Spec.Containers} {
+		for _, c := range containers {
+			for _, port := range c.Ports {
+				if port.HostPort != 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// hasTLSRoutes tells if the component is exposed by routes known to use TLS
+func (c Component) hasTLSRoutes() bool {
+	for _, r := range c.RouteDetails {
+		if r.TLSTermination != "" && r.TLSTermination != tlsTerminationNone {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsTargetPorts are the ports of the pods receiving TLS from passthrough
+// or reencrypt routes, as protocol/port
+func (c Component) tlsTargetPorts() []string {
+	ports := []string{}
+	for _, r := range c.RouteDetails {
+		if r.TargetPort != "" && (r.TLSTermination == string(routev1.TLSTerminationPassthrough) || r.TLSTermination == string(routev1.TLSTerminationReencrypt)) {
+			ports = appendUnique(ports, r.TargetPort)
+		}
+	}
+	return ports
+}
+
+// onlyTLSExposed tells if the component is exposed outside the cluster by
+// routes only, all of them terminating TLS without serving plain HTTP
+func (c Component) onlyTLSExposed() bool {
+	if len(c.RouteDetails) == 0 || len(c.InsecureExposures) > 0 {
+		return false
+	}
+	for _, by := range c.ExposedBy {
+		if by != "Route" && by != "Ingress" {
+			return false
+		}
+	}
+	for _, r := range c.RouteDetails {
+		if r.TLSTermination == "" || r.TLSTermination == tlsTerminationNone || r.PlainHTTP {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTargetPort resolves the port of the pods a Route sends traffic to:
+// spec.port.targetPort names or numbers a port of the service, whose
+// targetPort can in turn name a container port. A route without a port
+// uses the service's only port.
+func routeTargetPort(r routev1.Route, s corev1.Service, pods []corev1.Pod) string {
+	var port *corev1.ServicePort
+	for i, p := range s.Spec.Ports {
+		switch {
+		case r.Spec.Port == nil:
+			if len(s.Spec.Ports) == 1 {
+				port = &s.Spec.Ports[i]
+			}
+		case r.Spec.Port.TargetPort.Type == intstr.String:
+			if p.Name == r.Spec.Port.TargetPort.StrVal {
+				port = &s.Spec.Ports[i]
+			}
+		case p.TargetPort.Type == intstr.Int && p.TargetPort.IntVal == r.Spec.Port.TargetPort.IntVal,
+			p.TargetPort.Type == intstr.Int && p.TargetPort.IntVal == 0 && p.Port == r.Spec.Port.TargetPort.IntVal,
+			p.TargetPort.Type == intstr.String && containerPort(pods, p.TargetPort.StrVal) == r.Spec.Port.TargetPort.IntVal:
+			port = &s.Spec.Ports[i]
+		}
+	}
+	if port == nil {
+		return ""
+	}
+
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	number := port.TargetPort.IntVal
+	switch {
+	case port.TargetPort.Type == intstr.String:
+		number = containerPort(pods, port.TargetPort.StrVal)
+	case number == 0:
+		number = port.Port
+	}
+	if number == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", protocol, number)
+}
+
+// containerPort finds the number of a named container port, 0 when none of
+// the pods have it
+func containerPort(pods []corev1.Pod, name string) int32 {
+	for _, p := range pods {
+		for _, c := range podContainers(p) {
+			for _, port := range c.Ports {
+				if port.Name == name {
+					return port.ContainerPort
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// plainHTTPFindings describes the routes serving the component over plain
+// HTTP
+func plainHTTPFindings(routes []RouteInfo) []string {
+	findings := []string{}
+	for _, r := range routes {
+		if !r.PlainHTTP {
+			continue
+		}
+		reason := "without TLS"
+		if r.TLSTermination != tlsTerminationNone {
+			reason = "with insecureEdgeTerminationPolicy Allow"
+		}
+		findings = append(findings, fmt.Sprintf("%s %s exposes %s over plain HTTP %s", r.Kind, r.Name, r.Host+r.Path, reason))
+	}
+	return findings
+}
+
+func serviceInfoStrings(services []ServiceInfo) []string {
+	s := make([]string, 0, len(services))
+	for _, i := range services {
+		s = append(s, i.String())
+	}
+	return s
+}
+
+func routeInfoStrings(routes []RouteInfo) []string {
+	s := make([]string, 0, len(routes))
+	for _, r := range routes {
+		s = append(s, r.String())
+	}
+	return s
+}
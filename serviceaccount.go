@@ -0,0 +1,61 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// lastAppliedAnnotation can hold a copy of the secret's data
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// tokenSecretsMetadata keeps the service account token secrets, without
+// their data. Only their names and service accounts are needed, and they
+// end up in snapshots.
+func tokenSecretsMetadata(secrets []corev1.Secret) []corev1.Secret {
+	tokens := []corev1.Secret{}
+	for _, s := range secrets {
+		if s.Type != corev1.SecretTypeServiceAccountToken {
+			continue
+		}
+		annotations := make(map[string]string)
+		for k, v := range s.Annotations {
+			if k != lastAppliedAnnotation {
+				annotations[k] = v
+			}
+		}
+		tokens = append(tokens, corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        s.Name,
+				Namespace:   s.Namespace,
+				Annotations: annotations,
+			},
+			Type: s.Type,
+		})
+	}
+	return tokens
+}
+
+// serviceAccount finds a service account, nil when it's not known
+func serviceAccount(serviceAccounts []corev1.ServiceAccount, namespace string, name string) *corev1.ServiceAccount {
+	for i, sa := range serviceAccounts {
+		if sa.Namespace == namespace && sa.Name == name {
+			return &serviceAccounts[i]
+		}
+	}
+	return nil
+}
+
+// namespaceSecrets are the secrets of a namespace, nil when secrets
+// couldn't be listed
+func namespaceSecrets(secrets []corev1.Secret, collected bool, namespace string) []corev1.Secret {
+	if !collected {
+		return nil
+	}
+	inNamespace := []corev1.Secret{}
+	for _, s := range secrets {
+		if s.Namespace == namespace {
+			inNamespace = append(inNamespace, s)
+		}
+	}
+	return inNamespace
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	routev1 "github.com/openshift/api/route/v1"
+	securityv1 "github.com/openshift/api/security/v1"
+	"github.com/sfowl/pod-checker/pkg/helpers"
+	"golang.org/x/exp/slices"
+	yamlv2 "gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// A snapshot is a directory holding one List per resource type, as returned
+// by the API server. It can be written from a live cluster with -dump and
+// read back with -snapshot, so reports can be reproduced without cluster
+// access.
+const (
+	snapshotNamespaces           = "namespaces"
+	snapshotPods                 = "pods"
+	snapshotReplicaSets          = "replicasets"
+	snapshotServices             = "services"
+	snapshotRoutes               = "routes"
+	snapshotIngresses            = "ingresses"
+	snapshotHTTPRoutes           = "httproutes"
+	snapshotNetworkPolicies      = "networkpolicies"
+	snapshotAdminNetworkPolicies = "adminnetworkpolicies"
+	snapshotServiceAccounts      = "serviceaccounts"
+	snapshotSecrets              = "secrets"
+	snapshotRoles                = "roles"
+	snapshotClusterRoles         = "clusterroles"
+	snapshotRoleBindings         = "rolebindings"
+	snapshotClusterRoleBindings  = "clusterrolebindings"
+	snapshotSCCs                 = "securitycontextconstraints"
+)
+
+// snapshotStatusFile records the resource types that couldn't be collected
+// when the snapshot was written, their lists are left out
+const snapshotStatusFile = "collection_status.yaml"
+
+var snapshotExtensions = []string{".yaml", ".yml", ".json"}
+
+// loadSnapshot reads ClusterData from a snapshot directory or .tar.gz archive
+func loadSnapshot(path string) (ClusterData, error) {
+	dir := path
+	if helpers.IsArchive(path) {
+		tmpDir, err := helpers.ExtractArchive(path)
+		if err != nil {
+			return ClusterData{}, err
+		}
+		defer os.RemoveAll(tmpDir)
+		dir = tmpDir
+	}
+
+	var namespaces corev1.NamespaceList
+	var pods corev1.PodList
+	var replicaSets appsv1.ReplicaSetList
+	var services corev1.ServiceList
+	var routes routev1.RouteList
+	var ingresses networkingv1.IngressList
+	var httpRoutes unstructured.UnstructuredList
+	var networkPolicies networkingv1.NetworkPolicyList
+	var adminNetworkPolicies unstructured.UnstructuredList
+	var serviceAccounts corev1.ServiceAccountList
+	var secrets corev1.SecretList
+	var roles rbacv1.RoleList
+	var clusterRoles rbacv1.ClusterRoleList
+	var roleBindings rbacv1.RoleBindingList
+	var clusterRoleBindings rbacv1.ClusterRoleBindingList
+	var sccs securityv1.SecurityContextConstraintsList
+
+	lists := []struct {
+		name string
+		list runtime.Object
+	}{
+		{snapshotPods, &pods},
+		{snapshotReplicaSets, &replicaSets},
+		{snapshotNamespaces, &namespaces},
+		{snapshotServices, &services},
+		{snapshotRoutes, &routes},
+		{snapshotIngresses, &ingresses},
+		{snapshotHTTPRoutes, &httpRoutes},
+		{snapshotNetworkPolicies, &networkPolicies},
+		{snapshotAdminNetworkPolicies, &adminNetworkPolicies},
+		{snapshotServiceAccounts, &serviceAccounts},
+		{snapshotSecrets, &secrets},
+		{snapshotRoles, &roles},
+		{snapshotClusterRoles, &clusterRoles},
+		{snapshotRoleBindings, &roleBindings},
+		{snapshotClusterRoleBindings, &clusterRoleBindings},
+		{snapshotSCCs, &sccs},
+	}
+	status, err := readSnapshotStatus(dir)
+	if err != nil {
+		return ClusterData{}, err
+	}
+
+	clusterData := ClusterData{}
+	for _, l := range lists {
+		found, err := readSnapshotList(dir, l.name, l.list)
+		if err != nil {
+			return ClusterData{}, err
+		}
+		if found {
+			clusterData.collected(l.name, meta.LenList(l.list))
+			continue
+		}
+
+		notFound := &CollectionError{
+			Resource: l.name,
+			Reason:   metav1.StatusReasonNotFound,
+			Err:      fmt.Errorf("no %s file in snapshot %s", l.name, path),
+		}
+		// keep why it couldn't be collected when the snapshot was written
+		if i := slices.IndexFunc(status, func(s CollectionStatus) bool { return s.Resource == l.name && !s.Collected }); i != -1 {
+			notFound.Reason = status[i].Reason
+			notFound.Err = errors.New(status[i].Error)
+		}
+		if l.name == snapshotPods {
+			return ClusterData{}, notFound
+		}
+		clusterData.missing(notFound)
+	}
+
+	clusterData.Namespaces = namespacesByName(namespaces.Items)
+	clusterData.Pods = pods.Items
+	clusterData.ReplicaSets = replicaSets.Items
+	clusterData.Routes = routes.Items
+	clusterData.Ingresses = ingresses.Items
+	clusterData.HTTPRoutes = httpRoutes.Items
+	clusterData.NetworkPolicies = networkPolicies.Items
+	clusterData.AdminNetworkPolicies = adminNetworkPolicies.Items
+	clusterData.APIVersions = make(map[string]string)
+	for _, l := range []unstructured.UnstructuredList{httpRoutes, adminNetworkPolicies} {
+		if gv, err := schema.ParseGroupVersion(l.GetAPIVersion()); err == nil && gv.Group != "" {
+			clusterData.APIVersions[gv.Group] = gv.Version
+		}
+	}
+	clusterData.ServiceAccounts = serviceAccounts.Items
+	// a secrets file may come from oc get, with the data of all secrets
+	clusterData.Secrets = tokenSecretsMetadata(secrets.Items)
+	clusterData.Roles = roles.Items
+	clusterData.ClusterRoles = clusterRoles.Items
+	clusterData.RoleBindings = roleBindings.Items
+	clusterData.ClusterRoleBindings = clusterRoleBindings.Items
+	clusterData.SecurityContextConstraints = sccs.Items
+	clusterData.ServicesByNamespace = servicesByNamespace(services.Items)
+	clusterData.Platform = inferPlatform(clusterData)
+
+	return clusterData, nil
+}
+
+// readSnapshotList decodes <dir>/<name>.{yaml,yml,json} into list, reporting
+// whether a file was found.
+func readSnapshotList(dir string, name string, list interface{}) (bool, error) {
+	for _, ext := range snapshotExtensions {
+		file := filepath.Join(dir, name+ext)
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		if err := yaml.Unmarshal(data, list); err != nil {
+			return false, fmt.Errorf("unable to decode %s: %w", file, err)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// readSnapshotStatus reads the collection status of a snapshot, snapshots
+// written by hand don't have one
+func readSnapshotStatus(dir string) ([]CollectionStatus, error) {
+	file := filepath.Join(dir, snapshotStatusFile)
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var status []CollectionStatus
+	if err := yamlv2.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", file, err)
+	}
+	return status, nil
+}
+
+// dumpSnapshot writes ClusterData to dir in the format read by loadSnapshot.
+// The lists of resource types that weren't collected are left out, so they
+// aren't read back as empty.
+func dumpSnapshot(clusterData ClusterData, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	namespaces := corev1.NamespaceList{}
+	for _, n := range clusterData.Namespaces {
+		namespaces.Items = append(namespaces.Items, n)
+	}
+	sort.Slice(namespaces.Items, func(i, j int) bool {
+		return namespaces.Items[i].Name < namespaces.Items[j].Name
+	})
+	namespaces.APIVersion, namespaces.Kind = "v1", "NamespaceList"
+
+	services := corev1.ServiceList{}
+	for _, svcs := range clusterData.ServicesByNamespace {
+		services.Items = append(services.Items, svcs...)
+	}
+	sort.Slice(services.Items, func(i, j int) bool {
+		a, b := services.Items[i], services.Items[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+	services.APIVersion, services.Kind = "v1", "ServiceList"
+
+	pods := corev1.PodList{Items: clusterData.Pods}
+	pods.APIVersion, pods.Kind = "v1", "PodList"
+	replicaSets := appsv1.ReplicaSetList{Items: clusterData.ReplicaSets}
+	replicaSets.APIVersion, replicaSets.Kind = "apps/v1", "ReplicaSetList"
+	routes := routev1.RouteList{Items: clusterData.Routes}
+	routes.APIVersion, routes.Kind = "route.openshift.io/v1", "RouteList"
+	ingresses := networkingv1.IngressList{Items: clusterData.Ingresses}
+	ingresses.APIVersion, ingresses.Kind = "networking.k8s.io/v1", "IngressList"
+	httpRoutes := unstructured.UnstructuredList{Items: clusterData.HTTPRoutes}
+	httpRoutes.SetAPIVersion(gatewayAPIGroup + "/" + clusterData.apiVersion(gatewayAPIGroup, "v1beta1"))
+	httpRoutes.SetKind("HTTPRouteList")
+	networkPolicies := networkingv1.NetworkPolicyList{Items: clusterData.NetworkPolicies}
+	networkPolicies.APIVersion, networkPolicies.Kind = "networking.k8s.io/v1", "NetworkPolicyList"
+	adminNetworkPolicies := unstructured.UnstructuredList{Items: clusterData.AdminNetworkPolicies}
+	adminNetworkPolicies.SetAPIVersion(adminNetworkPolicyAPIGroup + "/" + clusterData.apiVersion(adminNetworkPolicyAPIGroup, "v1alpha1"))
+	adminNetworkPolicies.SetKind("AdminNetworkPolicyList")
+	serviceAccounts := corev1.ServiceAccountList{Items: clusterData.ServiceAccounts}
+	serviceAccounts.APIVersion, serviceAccounts.Kind = "v1", "ServiceAccountList"
+	secrets := corev1.SecretList{Items: clusterData.Secrets}
+	secrets.APIVersion, secrets.Kind = "v1", "SecretList"
+	roles := rbacv1.RoleList{Items: clusterData.Roles}
+	roles.APIVersion, roles.Kind = "rbac.authorization.k8s.io/v1", "RoleList"
+	clusterRoles := rbacv1.ClusterRoleList{Items: clusterData.ClusterRoles}
+	clusterRoles.APIVersion, clusterRoles.Kind = "rbac.authorization.k8s.io/v1", "ClusterRoleList"
+	roleBindings := rbacv1.RoleBindingList{Items: clusterData.RoleBindings}
+	roleBindings.APIVersion, roleBindings.Kind = "rbac.authorization.k8s.io/v1", "RoleBindingList"
+	clusterRoleBindings := rbacv1.ClusterRoleBindingList{Items: clusterData.ClusterRoleBindings}
+	clusterRoleBindings.APIVersion, clusterRoleBindings.Kind = "rbac.authorization.k8s.io/v1", "ClusterRoleBindingList"
+	sccs := securityv1.SecurityContextConstraintsList{Items: clusterData.SecurityContextConstraints}
+	sccs.APIVersion, sccs.Kind = securityAPIGroup+"/v1", "SecurityContextConstraintsList"
+
+	lists := []struct {
+		name string
+		list runtime.Object
+	}{
+		{snapshotPods, &pods},
+		{snapshotReplicaSets, &replicaSets},
+		{snapshotNamespaces, &namespaces},
+		{snapshotServices, &services},
+		{snapshotRoutes, &routes},
+		{snapshotIngresses, &ingresses},
+		{snapshotHTTPRoutes, &httpRoutes},
+		{snapshotNetworkPolicies, &networkPolicies},
+		{snapshotAdminNetworkPolicies, &adminNetworkPolicies},
+		{snapshotServiceAccounts, &serviceAccounts},
+		{snapshotSecrets, &secrets},
+		{snapshotRoles, &roles},
+		{snapshotClusterRoles, &clusterRoles},
+		{snapshotRoleBindings, &roleBindings},
+		{snapshotClusterRoleBindings, &clusterRoleBindings},
+		{snapshotSCCs, &sccs},
+	}
+	for _, l := range lists {
+		if !clusterData.isCollected(l.name) {
+			continue
+		}
+		data, err := yaml.Marshal(l.list)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, l.name+".yaml"), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filepath.Join(dir, snapshotStatusFile), marshalYAML(clusterData.Status), 0644)
+}
+
+// apiVersion is the version the resources of an API group were listed with,
+// fallback when unknown
+func (d ClusterData) apiVersion(group string, fallback string) string {
+	if version := d.APIVersions[group]; version != "" {
+		return version
+	}
+	return fallback
+}
+
+func namespacesByName(ns []corev1.Namespace) map[string]corev1.Namespace {
+	namespaces := make(map[string]corev1.Namespace)
+	for _, n := range ns {
+		namespaces[n.Name] = n
+	}
+	return namespaces
+}
+
+func servicesByNamespace(svcs []corev1.Service) map[string][]corev1.Service {
+	services := make(map[string][]corev1.Service)
+	for _, s := range svcs {
+		services[s.Namespace] = append(services[s.Namespace], s)
+	}
+	return services
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strings"
+
+	"github.com/sfowl/pod-checker/pkg/sachecker"
 )
 
 type Question struct {
@@ -36,6 +38,10 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 	survey := make([]SurveyComponent, 0)
 
 	for _, c := range components {
+		if c.IsExternal() {
+			// nothing to survey outside the cluster
+			continue
+		}
 		s := SurveyComponent{}
 		operatorHint := "Answer Yes → Go to “Operators” section"
 		s.Intro = Topic{
@@ -65,12 +71,19 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 				},
 			},
 		}
+		inboundPlainHTTP := ""
+		if len(c.InsecureExposures) > 0 {
+			inboundPlainHTTP = "yes: " + strings.Join(c.InsecureExposures, "; ")
+		} else if c.hasTLSRoutes() {
+			inboundPlainHTTP = "no plain HTTP routes"
+		}
 		outboundHint := "Even peer-to-peer communication within the same cluster"
 		s.Communications = Topic{
 			Name: "Communications",
 			Questions: []Question{
 				Question{
 					Question: "Does the component present inbound non-encrypted interfaces (eg, HTTP) ? ",
+					Answer:   inboundPlainHTTP,
 				},
 				Question{
 					Question: "Does the component enforce encryption on outbound communications (eg. HTTPS, TLS, etc) ? ",
@@ -78,7 +91,7 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 				},
 				Question{
 					Question: "Are the component's communications restricted by network policies, either ingress or egress?",
-					Answer:   "no",
+					Answer:   c.NetworkPolicy.Isolation(),
 				},
 			},
 		}
@@ -87,12 +100,15 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 			Questions: []Question{
 				Question{
 					Question: "Does the service account bound to the component use short-lived tokens? ",
+					Answer:   shortLivedTokens(c.ServiceAccountTokens),
 				},
 				Question{
 					Question: "Is there any long-lived token bound to the service account ?",
+					Answer:   longLivedTokens(c.ServiceAccountTokens),
 				},
 				Question{
 					Question: "Is there any use of wildcards in the Roles and/or ClusterRoles assigned to the service account bound to the component? Both in the namespace field and in the permissions field",
+					Answer:   rbacWildcards(c.RBAC),
 				},
 			},
 		}
@@ -144,8 +160,12 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 			extraCaps = fmt.Sprintf("yes: %v", c.SecurityContext.Capabilities.Add)
 		}
 		restrictedHint := "Answer No → Go to Security Context Section"
+		restrictedQuestion := "Do the pods that are part of the component have their security context restricted with the \"restricted-v2\" SCC? "
+		if platform == PlatformKubernetes {
+			restrictedQuestion = "Do the pods that are part of the component run in a namespace enforcing the \"restricted\" Pod Security Standard? "
+		}
 		isRestricted := "false"
-		if c.SCC == "restricted-v2" {
+		if c.SCC == "restricted-v2" || (c.SCC == "" && c.PodSecurityLevel == "restricted") {
 			isRestricted = "true"
 		}
 		s.PodSecurity = Topic{
@@ -156,7 +176,7 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 					Answer:   c.RunLevel,
 				},
 				Question{
-					Question: "Do the pods that are part of the component have their security context restricted with the \"restricted-v2\" SCC? ",
+					Question: restrictedQuestion,
 					Hint:     &restrictedHint,
 					Answer:   isRestricted,
 				},
@@ -218,8 +238,96 @@ func genSurvey(components map[string]Component) []SurveyComponent {
 				},
 			},
 		}
+		allowedIngress, allowedEgress, adminRules := "", "", ""
+		if c.NetworkPolicy != nil {
+			allowedIngress = strings.Join(c.NetworkPolicy.AllowedIngress, "; ")
+			allowedEgress = strings.Join(c.NetworkPolicy.AllowedEgress, "; ")
+			adminRules = strings.Join(c.NetworkPolicy.AdminRules, "; ")
+		}
+		s.NetworkPolicies = Topic{
+			Name: "Network Policies",
+			Questions: []Question{
+				Question{
+					Question: "Which network policies select the component's pods?",
+					Answer:   strings.Join(c.NetworkPolicy.policies(), ", "),
+				},
+				Question{
+					Question: "Which peers are allowed to connect to the component?",
+					Answer:   allowedIngress,
+				},
+				Question{
+					Question: "Which peers is the component allowed to connect to?",
+					Answer:   allowedEgress,
+				},
+				Question{
+					Question: "Which cluster wide (admin) network policy rules apply to the component?",
+					Answer:   adminRules,
+				},
+			},
+		}
 		survey = append(survey, s)
 	}
 
 	return survey
 }
+
+// rbacWildcards lists the roles granting wildcard permissions to the
+// component's service account, and whether they apply cluster wide
+func rbacWildcards(p *sachecker.Permissions) string {
+	if p == nil {
+		return ""
+	}
+	if !p.Wildcard {
+		return "no"
+	}
+	grants := []string{}
+	for _, r := range p.Rules {
+		if !r.HasWildcard() {
+			continue
+		}
+		scope := "in namespace " + r.Namespace
+		if r.Namespace == "" {
+			scope = "cluster wide"
+		}
+		grants = appendUnique(grants, fmt.Sprintf("%s through %s %s", r.Role, r.Binding, scope))
+	}
+	return "yes: " + strings.Join(grants, "; ")
+}
+
+// shortLivedTokens tells if the pods get bound tokens, and how long they're
+// valid
+func shortLivedTokens(t *sachecker.Tokens) string {
+	if t == nil {
+		return ""
+	}
+	if len(t.ProjectedTokens) == 0 {
+		if len(t.MountedLegacySecrets) > 0 {
+			return "no: only long-lived tokens are mounted"
+		}
+		return "no token mounted"
+	}
+	tokens := []string{}
+	for _, p := range t.ProjectedTokens {
+		token := fmt.Sprintf("%s expiring after %ds", p.Path, p.ExpirationSeconds)
+		if p.Audience != "" {
+			token += " for " + p.Audience
+		}
+		tokens = append(tokens, token)
+	}
+	return "yes: " + strings.Join(tokens, "; ")
+}
+
+// longLivedTokens lists the legacy token secrets of the service account
+func longLivedTokens(t *sachecker.Tokens) string {
+	switch {
+	case t == nil:
+		return ""
+	case len(t.LegacySecrets) == 0 && !t.SecretsListed:
+		return "unknown, secrets couldn't be listed"
+	case len(t.LegacySecrets) == 0:
+		return "no"
+	case len(t.MountedLegacySecrets) > 0:
+		return fmt.Sprintf("yes, mounted in the pods: %s", strings.Join(t.MountedLegacySecrets, ", "))
+	}
+	return fmt.Sprintf("yes, not mounted in the pods: %s", strings.Join(t.LegacySecrets, ", "))
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	tm "github.com/threagile/threagile/model"
+	"golang.org/x/exp/slices"
 )
 
 func convertID(id string) string {
@@ -18,7 +19,7 @@ func genThreagile(components map[string]Component) tm.ModelInput {
 		Threagile_version:    tm.ThreagileVersion,
 		Date:                 time.Now().String()[:10],
 		Business_criticality: "important", // required
-		Tags_available:       []string{"privileged", "hostNetwork"},
+		Tags_available:       []string{"privileged", "hostNetwork", "plain-http"},
 		// at least one data asset
 		Data_assets: map[string]tm.InputDataAsset{
 			"some-data": tm.InputDataAsset{
@@ -50,20 +51,43 @@ func genThreagile(components map[string]Component) tm.ModelInput {
 			Availability:           "operational",        // required
 			Data_assets_processed:  []string{"some-data"},
 		}
+		if c.onlyTLSExposed() {
+			// TLS to and from outside the cluster is set up by the routes,
+			// transparently to the component
+			ta.Encryption = "transparent"
+		}
+		switch c.DeployedAs {
+		case deployedAsExternal:
+			ta.Type = "external-entity"
+			ta.Size = "system"
+			ta.Machine = "virtual"
+			ta.Internet = c.hasPublicAddress()
+			ta.Custom_developed_parts = false
+		case deployedAsNode:
+			ta.Size = "system"
+			ta.Machine = "virtual"
+			ta.Custom_developed_parts = false
+		}
 		comms := make(map[string]tm.InputCommunicationLink)
 		for _, o := range c.OutgoingConnections {
-			if _, ok := components[o]; !ok {
+			if _, ok := components[o.Component]; !ok {
 				// can't add links to unknown assets
 				continue
 			}
 
-			targetID := convertID(o)
+			targetID := convertID(o.Component)
 			l := tm.InputCommunicationLink{
 				Target:         targetID,
-				Authentication: "none",             // required
-				Authorization:  "none",             // required
-				Usage:          "devops",           // required
-				Protocol:       "unknown-protocol", // required
+				Description:    o.Summary(),
+				Authentication: "none",               // required
+				Authorization:  "none",               // required
+				Usage:          "devops",             // required
+				Protocol:       threagileProtocol(o), // required
+			}
+			if l.Protocol == "http" && isSubset(o.Ports, components[o.Component].tlsTargetPorts()) {
+				// the routes to the target pass TLS through or re-encrypt to
+				// these ports
+				l.Protocol = "https"
 			}
 
 			comms[targetID] = l
@@ -71,12 +95,15 @@ func genThreagile(components map[string]Component) tm.ModelInput {
 		ta.Communication_links = comms
 
 		tags := make([]string, 0)
-		if c.SCC == "privileged" {
-			tags = append(tags, c.SCC)
+		if c.SCC == "privileged" || c.SecurityContext.Privileged {
+			tags = append(tags, "privileged")
 		}
 		if c.HostNetwork == true {
 			tags = append(tags, "hostNetwork")
 		}
+		if len(c.InsecureExposures) > 0 {
+			tags = append(tags, "plain-http")
+		}
 		ta.Tags = tags
 
 		technicalAssets[assetID] = ta
@@ -85,3 +112,48 @@ func genThreagile(components map[string]Component) tm.ModelInput {
 
 	return report
 }
+
+// threagileProtocols are the threagile protocols of well known ports
+var threagileProtocols = map[string]string{
+	"TCP/22":   "ssh",
+	"TCP/80":   "http",
+	"TCP/8080": "http",
+	"TCP/443":  "https",
+	"TCP/6443": "https",
+	"TCP/8443": "https",
+	"TCP/9443": "https",
+	"TCP/389":  "ldap",
+	"TCP/636":  "ldaps",
+	"TCP/2379": "binary-encrypted",
+	"TCP/3306": "sql-access-protocol",
+	"TCP/5432": "sql-access-protocol",
+	"TCP/53":   "binary",
+	"UDP/53":   "binary",
+}
+
+// threagileProtocol guesses the protocol of a connection from its ports,
+// when they all agree
+func threagileProtocol(c Connection) string {
+	protocol := ""
+	for _, port := range c.Ports {
+		p, ok := threagileProtocols[port]
+		if !ok || (protocol != "" && p != protocol) {
+			return "unknown-protocol"
+		}
+		protocol = p
+	}
+	if protocol == "" {
+		return "unknown-protocol"
+	}
+	return protocol
+}
+
+// isSubset tells if all the items are in set, and there's at least one
+func isSubset(items []string, set []string) bool {
+	for _, i := range items {
+		if !slices.Contains(set, i) {
+			return false
+		}
+	}
+	return len(items) > 0
+}
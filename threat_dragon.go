@@ -37,26 +37,29 @@ type Attrs struct {
 	Line       *Line `json:"line,omitempty"`
 	TopLine    *Line `json:"topLine,omitempty"`
 	BottomLine *Line `json:"bottomLine,omitempty"`
+	Label      *Text `json:"label,omitempty"`
 }
 
 type Cell struct {
-	Position  *CellPosition `json:"position,omitempty"`
-	Size      *CellSize     `json:"size,omitempty"`
-	Shape     string        `json:"shape"`
-	Attrs     Attrs         `json:"attrs,omitempty"`
-	Width     int           `json:"width,omitempty"`
-	Height    int           `json:"height,omitempty"`
-	ZIndex    int           `json:"zIndex"`
-	Connector string        `json:"connector,omitempty"`
-	Data      CellData      `json:"data"`
-	ID        string        `json:"id"`
-	Labels    []struct {
-		Position float32 `json:"position,omitempty"`
-		Attrs    Attrs   `json:"attrs"`
-	} `json:"labels,omitempty"`
-	Source   *CellName      `json:"source,omitempty"`
-	Target   *CellName      `json:"target,omitempty"`
-	Vertices []CellPosition `json:"vertices,omitempty"`
+	Position  *CellPosition  `json:"position,omitempty"`
+	Size      *CellSize      `json:"size,omitempty"`
+	Shape     string         `json:"shape"`
+	Attrs     Attrs          `json:"attrs,omitempty"`
+	Width     int            `json:"width,omitempty"`
+	Height    int            `json:"height,omitempty"`
+	ZIndex    int            `json:"zIndex"`
+	Connector string         `json:"connector,omitempty"`
+	Data      CellData       `json:"data"`
+	ID        string         `json:"id"`
+	Labels    []CellLabel    `json:"labels,omitempty"`
+	Source    *CellName      `json:"source,omitempty"`
+	Target    *CellName      `json:"target,omitempty"`
+	Vertices  []CellPosition `json:"vertices,omitempty"`
+}
+
+type CellLabel struct {
+	Position float32 `json:"position,omitempty"`
+	Attrs    Attrs   `json:"attrs"`
 }
 
 type CellPosition struct {
@@ -4,24 +4,35 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/exp/slices"
 )
 
-func getComponentEdges(component Component) map[string][]string {
-	edges := make(map[string][]string)
-	for _, s := range component.OutgoingConnections {
-		edgeName := fmt.Sprintf("%s to %s", component.Key(), s)
+// internetCellID is the actor standing for clients outside the cluster
+const internetCellID = "Internet"
+
+// componentEdge is a connection between two components of a diagram
+type componentEdge struct {
+	source     string
+	target     string
+	connection Connection
+}
+
+func getComponentEdges(component Component) map[string]componentEdge {
+	edges := make(map[string]componentEdge)
+	for _, c := range component.OutgoingConnections {
+		edgeName := fmt.Sprintf("%s to %s", component.Key(), c.Component)
 		if _, ok := edges[edgeName]; !ok {
-			edges[edgeName] = []string{component.Key(), s}
+			edges[edgeName] = componentEdge{source: component.Key(), target: c.Component, connection: c}
 		}
 	}
 
-	for _, s := range component.IncomingConnections {
-		edgeName := fmt.Sprintf("%s to %s", s, component.Key())
+	for _, c := range component.IncomingConnections {
+		edgeName := fmt.Sprintf("%s to %s", c.Component, component.Key())
 		if _, ok := edges[edgeName]; !ok {
-			edges[edgeName] = []string{s, component.Key()}
+			edges[edgeName] = componentEdge{source: c.Component, target: component.Key(), connection: c}
 		}
 	}
 
@@ -38,7 +49,7 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 	}
 
 	count := 0
-	edges := make(map[string][]string)
+	edges := make(map[string]componentEdge)
 	filteredComponents := make(map[string]Component)
 	for k, c := range components {
 		if c.Group != group {
@@ -51,7 +62,7 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 		for edgeKey, edge := range componentEdges {
 			////  log.Warnf("checking edge: %v", edge)
 			nodesMatched := true
-			for _, componentKey := range edge {
+			for _, componentKey := range []string{edge.source, edge.target} {
 				// log.Warnf("checking ckey: %v", componentKey)
 				if _, ok := filteredComponents[componentKey]; !ok {
 					if externalComponent, ok := components[componentKey]; ok {
@@ -70,6 +81,10 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 
 	// byNamespaces := make(map[string][]Cell)
 
+	// components reachable from outside the cluster get a flow from the
+	// internet actor
+	exposed := []Component{}
+
 	for _, c := range filteredComponents {
 		cell := Cell{
 			Position: &CellPosition{
@@ -103,6 +118,10 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 				Threats: []Threat{},
 			},
 		}
+		if c.ExternallyExposed {
+			cell.Data.Description = "Exposed by " + strings.Join(c.ExposedBy, ", ")
+			exposed = append(exposed, c)
+		}
 		diagram.Cells = append(diagram.Cells, cell)
 
 		// if _, ok := byNamespac[c.Namespace]; ok {
@@ -114,6 +133,10 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 		count++
 	}
 
+	if len(exposed) > 0 {
+		diagram.Cells = append(diagram.Cells, internetCells(exposed, count)...)
+	}
+
 	for e, edge := range edges {
 		cell := Cell{
 			Attrs: Attrs{
@@ -133,18 +156,27 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 			Height:    100,
 			Connector: "smooth",
 			Data: CellData{
-				Name:    e,
-				Type:    "tm.Flow",
-				Threats: []Threat{},
+				Name:        e,
+				Description: edge.connection.Summary(),
+				Type:        "tm.Flow",
+				Threats:     []Threat{},
 			},
 			Source: &CellName{
-				Cell: edge[0],
+				Cell: edge.source,
 			},
 			Target: &CellName{
-				Cell: edge[1],
+				Cell: edge.target,
 			},
 			Vertices: []CellPosition{},
 		}
+		if ports := strings.Join(edge.connection.Ports, ", "); ports != "" {
+			cell.Labels = []CellLabel{{
+				Position: 0.5,
+				Attrs: Attrs{
+					Label: &Text{Text: ports},
+				},
+			}}
+		}
 
 		diagram.Cells = append(diagram.Cells, cell)
 	}
@@ -152,7 +184,78 @@ func generateDiagram(group string, id int, components map[string]Component) Diag
 	return diagram
 }
 
-func generateThreatModel(components map[string]Component, outFilename string, excludedGroups []string) {
+// internetCells draws the internet as an actor, with a flow to each
+// externally exposed component labeled with its exposure mechanisms
+func internetCells(exposed []Component, count int) []Cell {
+	cells := []Cell{{
+		Position: &CellPosition{
+			X: 0 + ((count*100)%800)*2,
+			Y: 0 + (100*(count/8))*2,
+		},
+		Size: &CellSize{
+			Width:  160,
+			Height: 80,
+		},
+		Attrs: Attrs{
+			Text: &Text{
+				Text: internetCellID,
+			},
+		},
+		Shape:  "actor",
+		ID:     internetCellID,
+		ZIndex: 1,
+		Data: CellData{
+			Name:        internetCellID,
+			Description: "Clients outside the cluster",
+			Type:        "tm.Actor",
+			Threats:     []Threat{},
+		},
+	}}
+
+	for _, c := range exposed {
+		name := fmt.Sprintf("%s to %s", internetCellID, c.Key())
+		cells = append(cells, Cell{
+			Attrs: Attrs{
+				Line: &Line{
+					Stroke: "red",
+					TargetMarker: &TargetMarker{
+						Name: "classic",
+					},
+					StrokeWidth: 1,
+				},
+			},
+			Shape:     "flow",
+			ID:        name,
+			ZIndex:    10,
+			Width:     200,
+			Height:    100,
+			Connector: "smooth",
+			Data: CellData{
+				Name:        name,
+				Description: "Exposed by " + strings.Join(c.ExposedBy, ", "),
+				Type:        "tm.Flow",
+				IsEncrypted: len(c.InsecureExposures) == 0 && c.hasTLSRoutes(),
+				Threats:     []Threat{},
+			},
+			Source: &CellName{
+				Cell: internetCellID,
+			},
+			Target: &CellName{
+				Cell: c.Key(),
+			},
+			Vertices: []CellPosition{},
+			Labels: []CellLabel{{
+				Position: 0.5,
+				Attrs: Attrs{
+					Label: &Text{Text: strings.Join(c.ExposedBy, ", ")},
+				},
+			}},
+		})
+	}
+	return cells
+}
+
+func generateThreatModel(components map[string]Component, outFilename string, excludedGroups []string) error {
 	tm := ThreatModel{
 		Version: "2.0.1",
 		Summary: Summary{
@@ -163,7 +266,7 @@ func generateThreatModel(components map[string]Component, outFilename string, ex
 
 	diagrams := make([]Diagram, 0)
 	id := 0
-	for group, _ := range categorizedNamespaces {
+	for _, group := range grouper.groupNames() {
 		if slices.Contains(excludedGroups, group) {
 			continue
 		}
@@ -175,7 +278,10 @@ func generateThreatModel(components map[string]Component, outFilename string, ex
 
 	tm.Detail = Detail{Diagrams: diagrams}
 
-	file, _ := json.MarshalIndent(tm, "", " ")
+	file, err := json.MarshalIndent(tm, "", " ")
+	if err != nil {
+		return err
+	}
 
-	_ = ioutil.WriteFile(outFilename, file, 0644)
+	return ioutil.WriteFile(outFilename, file, 0644)
 }